@@ -0,0 +1,83 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// calculate applies an arithmetic operator. Operands of any numeric type are
+// widened to float64 first, like in comparisons. Times and durations are
+// handled by calculateTime and calculateDuration.
+func calculate(expr *parser.BinaryExpr, left, right interface{}) (interface{}, error) {
+	if result, ok, err := calculateTime(expr, left, right); ok {
+		return result, err
+	}
+
+	if result, ok, err := calculateDuration(expr, left, right); ok {
+		return result, err
+	}
+
+	x, ok, err := toFloat64(left)
+	if err != nil {
+		return nil, err
+	}
+
+	y, ok2, err := toFloat64(right)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid types for '%s': %T and %T", expr.Op, left, right)
+	}
+
+	switch expr.Op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero in '%s'", expr)
+		}
+
+		if expr.Op == "%" {
+			return math.Mod(x, y), nil
+		}
+
+		return x / y, nil
+	default:
+		return nil, fmt.Errorf("unknown arithmetic operator '%s'", expr.Op)
+	}
+}
+
+// negate applies a sign to a number or a duration.
+func negate(expr *parser.UnaryExpr, operand interface{}) (interface{}, error) {
+	if d, ok := operand.(time.Duration); ok {
+		if expr.Op == "-" {
+			return -d, nil
+		}
+
+		return d, nil
+	}
+
+	x, ok, err := toFloat64(operand)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("invalid type for '%s': %T", expr.Op, operand)
+	}
+
+	if expr.Op == "-" {
+		return -x, nil
+	}
+
+	return x, nil
+}
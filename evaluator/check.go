@@ -0,0 +1,172 @@
+package evaluator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alicavdar/logix/lexer"
+	"github.com/alicavdar/logix/parser"
+)
+
+// Check looks for mistakes in parsed nodes that can be found without a
+// context, such as calls to unknown functions or calls with the wrong number
+// of arguments. Like parse errors, they are returned as a parser.ErrorList so
+// that a rule is rejected when it is compiled rather than when it is first
+// evaluated. Check only knows the built-in operators; use Env.Check for rules
+// with custom ones.
+func Check(nodes []interface{}) error {
+	return defaultEnv.Check(nodes)
+}
+
+// Check is like the package-level Check, but also accepts the custom operators
+// of the Env and checks that they are given the right number of values.
+func (env *Env) Check(nodes []interface{}) error {
+	var errs parser.ErrorList
+	for _, node := range nodes {
+		env.checkNode(node, &errs)
+	}
+
+	return errs.Err()
+}
+
+func (env *Env) checkNode(node interface{}, errs *parser.ErrorList) {
+	switch node := node.(type) {
+	case *parser.Group:
+		for _, child := range node.Children {
+			env.checkNode(child, errs)
+		}
+	case *parser.Condition:
+		if !lexer.IsKeyword(node.Operator) {
+			token := lexer.Token{Kind: lexer.IDENT, Lexeme: node.Operator, Pos: node.Pos, End: node.End}
+
+			if op, ok := env.operators[node.Operator]; !ok {
+				*errs = append(*errs, &parser.ParseError{Pos: node.Pos, Token: token, Message: "unknown operator '" + node.Operator + "'"})
+			} else if op.Arity >= 0 && len(node.Value) != op.Arity {
+				noun := "values"
+				if op.Arity == 1 {
+					noun = "value"
+				}
+
+				message := fmt.Sprintf("operator '%s' expects %d %s, got %d", node.Operator, op.Arity, noun, len(node.Value))
+				*errs = append(*errs, &parser.ParseError{Pos: node.Pos, Token: token, Message: message})
+			}
+		}
+
+		if node.Left != nil {
+			env.checkExpr(node.Left, errs)
+		}
+
+		for _, value := range node.Value {
+			if expr, ok := value.(parser.Expr); ok {
+				env.checkExpr(expr, errs)
+			}
+		}
+	}
+}
+
+func (env *Env) checkExpr(expr parser.Expr, errs *parser.ErrorList) {
+	switch expr := expr.(type) {
+	case *parser.CallExpr:
+		token := lexer.Token{Kind: lexer.IDENT, Lexeme: expr.Func, Pos: expr.Pos, End: expr.End}
+
+		if fn, ok := env.function(expr.Func); !ok {
+			*errs = append(*errs, &parser.ParseError{Pos: expr.Pos, Token: token, Message: "unknown function '" + expr.Func + "'"})
+		} else if err := fn.checkArity(expr.Func, len(expr.Args)); err != nil {
+			*errs = append(*errs, &parser.ParseError{Pos: expr.Pos, Token: token, Message: err.Error()})
+		} else if env.checkArgs(expr, fn, errs) && fn.validate != nil {
+			if literals, ok := literalArgs(expr); ok {
+				if err := fn.validate(literals); err != nil {
+					message := fmt.Sprintf("function '%s': %v", expr.Func, err)
+					*errs = append(*errs, &parser.ParseError{Pos: expr.Pos, Token: token, Message: message})
+				}
+			}
+		}
+
+		for _, arg := range expr.Args {
+			env.checkExpr(arg, errs)
+		}
+	case *parser.UnaryExpr:
+		env.checkOperand(expr.Op, expr.Operand, false, errs)
+		env.checkExpr(expr.Operand, errs)
+	case *parser.BinaryExpr:
+		checkBareNow(expr, errs)
+		env.checkOperand(expr.Op, expr.Left, true, errs)
+		env.checkOperand(expr.Op, expr.Right, true, errs)
+		env.checkExpr(expr.Left, errs)
+		env.checkExpr(expr.Right, errs)
+	}
+}
+
+// checkArgs reports arguments whose type is known not to match the parameter.
+// It returns false if there were any.
+func (env *Env) checkArgs(expr *parser.CallExpr, fn function, errs *parser.ErrorList) bool {
+	ok := true
+	for i, arg := range expr.Args {
+		param, actual := fn.param(i), env.staticType(arg)
+		if param != TypeAny && actual != TypeAny && param != actual {
+			token := lexer.Token{Kind: lexer.IDENT, Lexeme: expr.Func, Pos: expr.Pos, End: expr.End}
+			message := fmt.Sprintf("function '%s' expects %s as argument %d, got %s", expr.Func, param, i+1, actual)
+			*errs = append(*errs, &parser.ParseError{Pos: expr.Pos, Token: token, Message: message})
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// literalArgs returns the values of the arguments of a call if they are all
+// literals.
+func literalArgs(expr *parser.CallExpr) ([]interface{}, bool) {
+	values := make([]interface{}, len(expr.Args))
+	for i, arg := range expr.Args {
+		literal, ok := arg.(*parser.Literal)
+		if !ok {
+			return nil, false
+		}
+
+		values[i] = literal.Value
+	}
+
+	return values, true
+}
+
+// checkOperand reports an operand of an arithmetic operator that is known not
+// to be a number, like a string literal or a call to lower. Durations are
+// accepted everywhere and times by binary + and -; which combinations work is
+// checked when the rule is evaluated.
+func (env *Env) checkOperand(op string, operand parser.Expr, binary bool, errs *parser.ErrorList) {
+	actual := env.staticType(operand)
+	if actual == TypeTime && binary && (op == "+" || op == "-") {
+		return
+	}
+
+	if actual != TypeAny && actual != TypeNumber && actual != TypeDuration {
+		pos := parser.ExprPos(operand)
+		token := lexer.Token{Kind: lexer.IDENT, Lexeme: operand.String(), Pos: pos}
+		message := fmt.Sprintf("operator '%s' expects numbers, got %s", op, actual)
+		*errs = append(*errs, &parser.ParseError{Pos: pos, Token: token, Message: message})
+	}
+}
+
+// checkBareNow reports a field named now that is moved by a duration literal,
+// as in `now - 7d`. Without parentheses now is a field rather than the clock,
+// so the rule would otherwise only fail once it is evaluated.
+func checkBareNow(expr *parser.BinaryExpr, errs *parser.ErrorList) {
+	if expr.Op != "+" && expr.Op != "-" {
+		return
+	}
+
+	for _, pair := range [][2]parser.Expr{{expr.Left, expr.Right}, {expr.Right, expr.Left}} {
+		ref, ok := pair[0].(*parser.FieldRef)
+		if !ok || ref.Path != "now" {
+			continue
+		}
+
+		if literal, ok := pair[1].(*parser.Literal); ok {
+			if _, ok := literal.Value.(time.Duration); ok {
+				token := lexer.Token{Kind: lexer.IDENT, Lexeme: ref.Path, Pos: ref.Pos, End: ref.End}
+				*errs = append(*errs, &parser.ParseError{Pos: ref.Pos, Token: token, Message: "'now' is a field here, use now() for the current time"})
+			}
+		}
+	}
+}
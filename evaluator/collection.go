@@ -0,0 +1,97 @@
+package evaluator
+
+import (
+	"reflect"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// toList returns the elements of an array field. Besides []interface{}, which
+// is what JSON decodes to, any Go slice or array is accepted, such as a
+// []string built by hand.
+func toList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+
+	if value == nil {
+		return nil, false
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+
+	return list, true
+}
+
+// evaluateCollection compares the elements of an array field with a list of
+// values:
+//
+//   - containsAny and intersects: the field shares at least one element with the values
+//   - containsAll: the field holds every one of the values
+//   - subsetOf: every element of the field is one of the values
+func evaluateCollection(list []interface{}, values parser.Value, operator string, negate bool) (bool, error) {
+	literals := make([]interface{}, len(values))
+	for i, value := range values {
+		literals[i] = value
+	}
+
+	var result bool
+	var err error
+
+	switch operator {
+	case "containsAny", "intersects":
+		result, err = containsAny(list, literals)
+	case "containsAll":
+		result, err = containsAll(list, literals)
+	case "subsetOf":
+		result, err = containsAll(literals, list)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return applyNegation(result, negate), nil
+}
+
+// containsAny reports whether the list holds at least one of the values.
+func containsAny(list []interface{}, values []interface{}) (bool, error) {
+	for _, element := range list {
+		for _, value := range values {
+			equal, err := valuesEqual(element, value)
+			if err != nil {
+				return false, err
+			}
+
+			if equal {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// containsAll reports whether the list holds every one of the values.
+func containsAll(list []interface{}, values []interface{}) (bool, error) {
+	for _, value := range values {
+		found, err := containsAny(list, []interface{}{value})
+		if err != nil {
+			return false, err
+		}
+
+		if !found {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,90 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alicavdar/logix/lexer"
+	"github.com/alicavdar/logix/parser"
+)
+
+// Env holds the custom operators and functions a set of rules can use on top
+// of the built-in ones. Register everything before compiling rules with the Env;
+// after that it is only read and can be shared by any number of rules and
+// goroutines.
+type Env struct {
+	operators map[string]Operator
+	functions map[string]function
+}
+
+// Operator is a custom operator implemented in Go.
+type Operator struct {
+	// Arity is the number of values the operator takes: 0 for an operator
+	// that only looks at the field, like exists, 1 for a single value, and
+	// larger numbers for a list of exactly that many values. -1 accepts a
+	// list of any length, and a single reference to an array field stands
+	// for its elements, as with in.
+	Arity int
+	// Negatable allows the operator to be written with not, which inverts
+	// its result.
+	Negatable bool
+	// Eval reports whether the condition holds for the field value. values
+	// holds the condition's values with field references resolved.
+	Eval func(field interface{}, values []interface{}) (bool, error)
+}
+
+func NewEnv() *Env {
+	return &Env{operators: map[string]Operator{}, functions: map[string]function{}}
+}
+
+// defaultEnv is used by programs that are not given an Env. It only has the
+// built-in operators and functions.
+var defaultEnv = NewEnv()
+
+// RegisterOperator adds a custom operator to the Env. The name must be a valid
+// identifier that is not already a Logix keyword or a registered operator.
+func (env *Env) RegisterOperator(name string, op Operator) error {
+	if lexer.IsKeyword(name) {
+		return fmt.Errorf("operator '%s' is a built-in keyword", name)
+	}
+
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid operator name '%s'", name)
+	}
+
+	if _, ok := env.operators[name]; ok {
+		return fmt.Errorf("operator '%s' is already registered", name)
+	}
+
+	if op.Eval == nil {
+		return fmt.Errorf("operator '%s' has no Eval function", name)
+	}
+
+	if op.Arity < -1 {
+		return fmt.Errorf("invalid arity %d for operator '%s'", op.Arity, name)
+	}
+
+	env.operators[name] = op
+	return nil
+}
+
+// DeclareOperators tells the parser how the custom operators of the Env are
+// written. Parsers for rules that use the Env must be set up with it.
+func (env *Env) DeclareOperators(p *parser.Parser) {
+	for name, op := range env.operators {
+		p.DeclareOperator(name, parser.OperatorSyntax{Unary: op.Arity == 0, Negatable: op.Negatable})
+	}
+}
+
+// isIdentifier reports whether the name is lexed as a single identifier that
+// is not a field path.
+func isIdentifier(name string) bool {
+	if strings.ContainsAny(name, ".[") {
+		return false
+	}
+
+	l := lexer.NewLexer(name)
+	token := l.Next()
+
+	return token.Kind == lexer.IDENT && token.Lexeme == name && l.Next().Kind == lexer.EOF
+}
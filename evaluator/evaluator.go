@@ -1,130 +1,418 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/alicavdar/logix/lexer"
 	"github.com/alicavdar/logix/parser"
 )
 
+var fieldPathPattern = regexp.MustCompile(`([\p{L}\p{N}_]+|\[\d+\]|\[\*\])`)
+
 func Evaluate(p *parser.Parser, context map[string]interface{}) (bool, error) {
-	for {
-		parsed := p.ParseNext()
-		if parsed == nil {
+	nodes, err := p.Parse()
+	if err != nil {
+		return false, err
+	}
+
+	if err := Check(nodes); err != nil {
+		return false, err
+	}
+
+	return EvaluateNodes(nodes, context)
+}
+
+// EvaluateNodes evaluates already parsed top-level nodes against the context.
+// The nodes are only read, so the same nodes can be evaluated concurrently.
+func EvaluateNodes(nodes []interface{}, context map[string]interface{}) (bool, error) {
+	return NewProgram(nodes, Options{}).Evaluate(context)
+}
+
+// EvalError is returned when a condition cannot be evaluated against a
+// context. Pos points at the condition in the Logix source.
+type EvalError struct {
+	Pos lexer.Position
+	Err error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.Pos.Line, e.Pos.Column, e.Err)
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+func (e *evaluation) evaluateCondition(cond *parser.Condition, trace *Trace) (bool, error) {
+	var fieldValue interface{}
+	var err error
+	if cond.Left != nil {
+		fieldValue, err = e.evaluateExpr(cond.Left)
+	} else {
+		fieldValue, err = resolveFieldValue(cond.Field, e.context)
+	}
+
+	var missing *missingFieldError
+	if errors.As(err, &missing) {
+		trace.setMissing()
+	} else if err != nil {
+		return trace.record(false, &EvalError{Pos: cond.Pos, Err: err})
+	}
+
+	values, err := e.resolveValues(cond)
+	if err != nil {
+		var missingValue *missingFieldError
+		if errors.As(err, &missingValue) && e.program.options.MissingField == MissingFieldFalse {
+			return trace.record(false, nil)
+		}
+
+		return trace.record(false, &EvalError{Pos: cond.Pos, Err: err})
+	}
+
+	if missing == nil {
+		trace.setValues(fieldValue, values)
+	}
+
+	var result bool
+	if set, ok := fieldValue.(matchSet); ok {
+		result, err = e.evaluateQuantified(cond, set, values)
+	} else if cond.Quantifier != "" && missing == nil {
+		list, ok := fieldValue.([]interface{})
+		if !ok {
+			return trace.record(false, &EvalError{
+				Pos: cond.Pos,
+				Err: fmt.Errorf("the field value is not an array for quantifier '%s'", cond.Quantifier),
+			})
+		}
+
+		set := make(matchSet, len(list))
+		for i, element := range list {
+			set[i] = fieldMatch{value: element}
+		}
+
+		result, err = e.evaluateQuantified(cond, set, values)
+	} else {
+		result, err = e.evaluateValue(cond, fieldValue, values, missing)
+	}
+
+	if err != nil {
+		return trace.record(false, &EvalError{Pos: cond.Pos, Err: err})
+	}
+
+	return trace.record(result, nil)
+}
+
+// evaluateQuantified checks the condition against every value in the set and
+// combines the results with the condition's quantifier. Without a quantifier,
+// a set matches if any of its values does.
+func (e *evaluation) evaluateQuantified(cond *parser.Condition, set matchSet, values parser.Value) (bool, error) {
+	for _, match := range set {
+		result, err := e.evaluateValue(cond, match.value, values, match.missing)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case result && (cond.Quantifier == "" || cond.Quantifier == "any"):
+			return true, nil
+		case result && cond.Quantifier == "none":
+			return false, nil
+		case !result && cond.Quantifier == "all":
+			return false, nil
+		}
+	}
+
+	// Nothing decided the result early: "any" found no match, while "all"
+	// and "none" hold, which is also their result for an empty set
+	return cond.Quantifier == "all" || cond.Quantifier == "none", nil
+}
+
+// evaluateValue checks the condition against a single field value, comparing
+// it with the resolved values of the condition. missing is set when the field
+// was not found in the context.
+func (e *evaluation) evaluateValue(cond *parser.Condition, fieldValue interface{}, values parser.Value, missing *missingFieldError) (bool, error) {
+	if missing != nil {
+		switch {
+		case cond.Operator == "exists":
+			return applyNegation(false, cond.Negate), nil
+		case e.program.options.MissingField == MissingFieldError,
+			e.program.options.MissingField == MissingFieldNil && missing.outOfRange:
+			return false, missing
+		case e.program.options.MissingField == MissingFieldFalse:
+			return false, nil
+		}
+	}
+
+	if op, ok := e.program.options.env().operators[cond.Operator]; ok {
+		args := make([]interface{}, len(values))
+		for i, value := range values {
+			args[i] = value
+		}
+
+		result, err := op.Eval(fieldValue, args)
+		if err != nil {
+			return false, fmt.Errorf("operator '%s': %w", cond.Operator, err)
+		}
+
+		return applyNegation(result, cond.Negate), nil
+	}
+
+	return applyOperator(cond, fieldValue, values)
+}
+
+// resolveValues returns the values of the condition with every field reference
+// replaced by the value it refers to. For operators that take a list, including
+// custom operators with an arity of -1, a single reference to an array stands
+// for its elements, so `role in allowed_roles` works like
+// `role in ["admin", "editor"]`.
+func (e *evaluation) resolveValues(cond *parser.Condition) (parser.Value, error) {
+	hasRefs := false
+	for _, value := range cond.Value {
+		if _, ok := value.(parser.Expr); ok {
+			hasRefs = true
 			break
 		}
+	}
 
-		switch item := parsed.(type) {
-		case *parser.Condition:
-			conditionResult, err := evaluateCondition(item, context)
-			if err != nil {
-				return false, err
-			}
+	if !hasRefs {
+		return cond.Value, nil
+	}
 
-			if !conditionResult {
-				return false, nil
-			}
-		case *parser.Group:
-			groupResult, err := evaluateGroup(item, context)
-			if err != nil {
-				return false, err
-			}
+	values := make(parser.Value, len(cond.Value))
+	for i, value := range cond.Value {
+		expr, ok := value.(parser.Expr)
+		if !ok {
+			values[i] = value
+			continue
+		}
+
+		resolved, err := e.evaluateOperand(expr)
+		if err != nil {
+			return nil, err
+		}
 
-			if !groupResult {
-				return false, nil
+		values[i] = resolved
+	}
+
+	spread := parser.SpreadsList(cond.Operator)
+	if op, ok := e.program.options.env().operators[cond.Operator]; ok {
+		spread = op.Arity == -1
+	}
+
+	if len(values) == 1 && spread {
+		if list, ok := toList(values[0]); ok {
+			values = make(parser.Value, len(list))
+			for i, element := range list {
+				values[i] = element
 			}
-		default:
-			return false, fmt.Errorf("unexpected item type: %T", parsed)
 		}
 	}
 
-	return true, nil
+	return values, nil
 }
 
-func evaluateCondition(cond *parser.Condition, context map[string]interface{}) (bool, error) {
-	fieldValue, err := resolveFieldValue(cond.Field, context)
-	if err != nil {
-		return false, err
+func applyOperator(cond *parser.Condition, fieldValue interface{}, values parser.Value) (bool, error) {
+	// Unary operators like exists have no value
+	var conditionValue interface{}
+	if len(values) > 0 {
+		conditionValue = values[0]
 	}
 
-	conditionValue := cond.Value[0]
-
 	switch cond.Operator {
+	case "exists":
+		return applyNegation(true, cond.Negate), nil
+	case "isEmpty":
+		length, ok := lengthOf(fieldValue)
+		if !ok {
+			return false, fmt.Errorf("the field value is not a string, an array or a map for 'isEmpty' operator")
+		}
+
+		return applyNegation(length == 0, cond.Negate), nil
 	case "lt", "gt", "lte", "gte":
+		if isTemporal(fieldValue, conditionValue) {
+			return compareTimes(fieldValue, conditionValue, cond.Operator, cond.Negate)
+		}
+
+		if isVersion(fieldValue, conditionValue) {
+			return compareVersions(fieldValue, conditionValue, cond.Operator, cond.Negate)
+		}
+
+		if isDuration(fieldValue, conditionValue) {
+			return compareDurations(fieldValue, conditionValue, cond.Operator, cond.Negate)
+		}
+
 		return compareNumeric(fieldValue, conditionValue, cond.Operator, cond.Negate)
+	case "before", "after":
+		return compareTimes(fieldValue, conditionValue, cond.Operator, cond.Negate)
 	case "eq":
-		return applyNegation(fieldValue == conditionValue, cond.Negate), nil
+		equal, err := valuesEqual(fieldValue, conditionValue)
+		if err != nil {
+			return false, err
+		}
+
+		return applyNegation(equal, cond.Negate), nil
 	case "neq":
-		return applyNegation(fieldValue != conditionValue, cond.Negate), nil
+		equal, err := valuesEqual(fieldValue, conditionValue)
+		if err != nil {
+			return false, err
+		}
+
+		return applyNegation(!equal, cond.Negate), nil
+	case "ieq":
+		equal, err := valuesEqualFold(fieldValue, conditionValue)
+		if err != nil {
+			return false, err
+		}
+
+		return applyNegation(equal, cond.Negate), nil
 	case "contains":
-		strVal, ok := fieldValue.(string)
+		if list, ok := toList(fieldValue); ok {
+			return evaluateCollection(list, parser.Value{conditionValue}, "containsAll", cond.Negate)
+		}
+
+		return compareStrings(fieldValue, conditionValue, cond.Operator, cond.Negate, strings.Contains)
+	case "containsAny", "containsAll", "subsetOf", "intersects":
+		list, ok := toList(fieldValue)
 		if !ok {
-			return false, fmt.Errorf("the field value is not a string for 'contains' operator")
+			return false, fmt.Errorf("the field value is not an array for '%s' operator", cond.Operator)
 		}
 
-		return applyNegation(strings.Contains(strVal, conditionValue.(string)), cond.Negate), nil
+		return evaluateCollection(list, values, cond.Operator, cond.Negate)
+	case "icontains":
+		return compareStrings(fieldValue, conditionValue, cond.Operator, cond.Negate, containsFold)
 	case "between":
-		return evaluateBetween(fieldValue, cond.Value, cond.Negate)
+		if isTemporal(fieldValue, values[0], values[1]) {
+			return timeBetween(fieldValue, values, cond.Negate)
+		}
+
+		if isVersion(fieldValue, values[0], values[1]) {
+			return versionBetween(fieldValue, values, cond.Negate)
+		}
+
+		if isDuration(fieldValue, values[0], values[1]) {
+			return durationBetween(fieldValue, values, cond.Negate)
+		}
+
+		return evaluateBetween(fieldValue, values, cond.Negate)
 	case "startsWith":
+		return compareStrings(fieldValue, conditionValue, cond.Operator, cond.Negate, strings.HasPrefix)
+	case "istartsWith":
+		return compareStrings(fieldValue, conditionValue, cond.Operator, cond.Negate, hasPrefixFold)
+	case "endsWith":
+		return compareStrings(fieldValue, conditionValue, cond.Operator, cond.Negate, strings.HasSuffix)
+	case "iendsWith":
+		return compareStrings(fieldValue, conditionValue, cond.Operator, cond.Negate, hasSuffixFold)
+	case "matches":
 		strVal, ok := fieldValue.(string)
 		if !ok {
-			return false, fmt.Errorf("the field value is not a string for 'startsWith' operator")
+			return false, fmt.Errorf("the field value is not a string for 'matches' operator")
 		}
 
-		return applyNegation(strings.HasPrefix(strVal, conditionValue.(string)), cond.Negate), nil
-	case "endsWith":
-		strVal, ok := fieldValue.(string)
+		re, ok := cond.Compiled.(*regexp.Regexp)
 		if !ok {
-			return false, fmt.Errorf("field value is not a string for 'endsWith' operator")
+			return false, fmt.Errorf("the pattern for 'matches' operator was not compiled")
 		}
 
-		return applyNegation(strings.HasSuffix(strVal, conditionValue.(string)), cond.Negate), nil
+		return applyNegation(re.MatchString(strVal), cond.Negate), nil
+	case "inCidr":
+		return evaluateInCidr(cond, fieldValue, values)
+	case "isPrivate":
+		return classifyAddr(cond, fieldValue, netip.Addr.IsPrivate)
+	case "isLoopback":
+		return classifyAddr(cond, fieldValue, netip.Addr.IsLoopback)
+	case "withinRadius":
+		return evaluateWithinRadius(cond, fieldValue, values)
+	case "insidePolygon":
+		return evaluateInsidePolygon(cond, fieldValue)
 	case "in":
-		return evaluateIn(fieldValue, cond.Value, cond.Negate)
+		return evaluateIn(fieldValue, values, cond.Negate, valuesEqual)
+	case "iin":
+		return evaluateIn(fieldValue, values, cond.Negate, valuesEqualFold)
 	default:
 		return false, fmt.Errorf("unknown operator '%s'", cond.Operator)
 	}
 }
 
-func evaluateGroup(group *parser.Group, context map[string]interface{}) (bool, error) {
-	var result bool
+func (e *evaluation) evaluateGroup(group *parser.Group, trace *Trace) (bool, error) {
+	return e.evaluateChildren(group.LogicalOp, group.Children, e.program.plans[group], trace)
+}
 
-	if group.LogicalOp == "and" {
-		result = true
-	} else {
-		result = false
+// evaluateChildren combines the children of a group, or the top-level nodes,
+// with the logical operator. It stops as soon as the result is decided: at the
+// first false child for "and" and at the first true child for "or".
+func (e *evaluation) evaluateChildren(logicalOp string, children []interface{}, plan *groupPlan, trace *Trace) (bool, error) {
+	// Traces are created up front so that they stay in source order whatever
+	// the evaluation order is. Children that are never reached stay skipped.
+	traces := trace.skippedChildren(children)
+	if trace != nil {
+		defer trace.expandSkipped()
 	}
 
-	for _, child := range group.Children {
-		switch child := child.(type) {
-		case *parser.Condition:
-			condResult, err := evaluateCondition(child, context)
-			if err != nil {
-				return false, err
-			}
+	decisive := logicalOp == "or"
 
-			if group.LogicalOp == "and" {
-				result = result && condResult
-			} else {
-				result = result || condResult
-			}
+	// An error is held back until the children before it in source order are
+	// known not to decide the group, because evaluating them as written would
+	// not have reached the failing child otherwise
+	var held error
+	heldAt := 0
+
+	order := plan.evaluationOrder(logicalOp)
+	for n, i := range order {
+		var childTrace *Trace
+		if traces != nil {
+			childTrace = traces[i]
+			childTrace.Skipped = false
+		}
+
+		var result bool
+		var err error
+
+		switch child := children[i].(type) {
+		case *parser.Condition:
+			result, err = e.evaluateCondition(child, childTrace)
 		case *parser.Group:
-			groupResult, err := evaluateGroup(child, context)
-			if err != nil {
-				return false, err
+			result, err = e.evaluateGroup(child, childTrace)
+		default:
+			return trace.record(false, fmt.Errorf("unexpected item type: %T", child))
+		}
+
+		if err != nil {
+			if held == nil || i < heldAt {
+				held, heldAt = err, i
 			}
+		} else {
+			plan.observe(i, result)
 
-			if group.LogicalOp == "and" {
-				result = result && groupResult
-			} else {
-				result = result || groupResult
+			if result == decisive {
+				return trace.record(decisive, nil)
 			}
 		}
+
+		if held != nil && reachedInSourceOrder(order[:n+1], heldAt) {
+			return trace.record(false, held)
+		}
 	}
 
-	return result, nil
+	return trace.record(!decisive, nil)
+}
+
+// reachedInSourceOrder reports whether the evaluated children include every
+// child before index i. None of them decided the group, so evaluating as
+// written would have reached child i.
+func reachedInSourceOrder(evaluated []int, i int) bool {
+	before := 0
+	for _, j := range evaluated {
+		if j < i {
+			before++
+		}
+	}
+
+	return before == i
 }
 
 func applyNegation(result bool, negate bool) bool {
@@ -135,9 +423,14 @@ func applyNegation(result bool, negate bool) bool {
 	return result
 }
 
-func evaluateIn(fieldValue interface{}, values parser.Value, negate bool) (bool, error) {
+func evaluateIn(fieldValue interface{}, values parser.Value, negate bool, equals func(a, b interface{}) (bool, error)) (bool, error) {
 	for _, val := range values {
-		if fieldValue == val {
+		equal, err := equals(fieldValue, val)
+		if err != nil {
+			return false, err
+		}
+
+		if equal {
 			return applyNegation(true, negate), nil
 		}
 	}
@@ -145,14 +438,41 @@ func evaluateIn(fieldValue interface{}, values parser.Value, negate bool) (bool,
 	return applyNegation(false, negate), nil
 }
 
+func compareStrings(fieldValue, conditionValue interface{}, operator string, negate bool, compare func(s, substr string) bool) (bool, error) {
+	strVal, ok := fieldValue.(string)
+	if !ok {
+		return false, fmt.Errorf("the field value is not a string for '%s' operator", operator)
+	}
+
+	conditionStr, ok := conditionValue.(string)
+	if !ok {
+		return false, fmt.Errorf("the value is not a string for '%s' operator", operator)
+	}
+
+	return applyNegation(compare(strVal, conditionStr), negate), nil
+}
+
 func compareNumeric(fieldValue, conditionValue interface{}, operator string, negate bool) (bool, error) {
-	fieldFloat, ok := fieldValue.(float64)
-	conditionFloat, ok2 := conditionValue.(float64)
+	fieldFloat, ok, err := toFloat64(fieldValue)
+	if err != nil {
+		return false, err
+	}
+
+	conditionFloat, ok2, err := toFloat64(conditionValue)
+	if err != nil {
+		return false, err
+	}
 
 	if !ok || !ok2 {
 		return false, fmt.Errorf("invalid types for numeric comparison: %T and %T", fieldValue, conditionValue)
 	}
 
+	// When the float64s are rounded, the exact values are compared through
+	// the sign of their difference
+	if x, y := exactNumbers(fieldValue, conditionValue); x != nil {
+		fieldFloat, conditionFloat = float64(x.Cmp(y)), 0
+	}
+
 	var result bool
 	switch operator {
 	case "lt":
@@ -169,48 +489,145 @@ func compareNumeric(fieldValue, conditionValue interface{}, operator string, neg
 }
 
 func evaluateBetween(fieldValue interface{}, values parser.Value, negate bool) (bool, error) {
-	fieldFloat, ok := fieldValue.(float64)
-	low, lowOk := values[0].(float64)
-	high, highOk := values[1].(float64)
+	fieldFloat, ok, err := toFloat64(fieldValue)
+	if err != nil {
+		return false, err
+	}
+
+	low, lowOk, err := toFloat64(values[0])
+	if err != nil {
+		return false, err
+	}
+
+	high, highOk, err := toFloat64(values[1])
+	if err != nil {
+		return false, err
+	}
 
 	if !ok || !lowOk || !highOk {
 		return false, fmt.Errorf("invalid types for 'between' operator")
 	}
 
-	result := fieldFloat >= low && fieldFloat <= high
-	return applyNegation(result, negate), nil
+	aboveLow, belowHigh := fieldFloat >= low, fieldFloat <= high
+	if x, y := exactNumbers(fieldValue, values[0]); x != nil {
+		aboveLow = x.Cmp(y) >= 0
+	}
+
+	if x, y := exactNumbers(fieldValue, values[1]); x != nil {
+		belowHigh = x.Cmp(y) <= 0
+	}
+
+	return applyNegation(aboveLow && belowHigh, negate), nil
+}
+
+// missingFieldError reports a field path that leads nowhere in the context:
+// a map key that does not exist, an index past the end of an array, or a path
+// that continues below a nil value. How it is handled depends on the
+// MissingFieldPolicy.
+type missingFieldError struct {
+	reason     string
+	outOfRange bool // an array index past the end, which MissingFieldNil still reports
+}
+
+func (e *missingFieldError) Error() string {
+	return e.reason
+}
+
+// matchSet holds what a field path with wildcards resolved to: one entry for
+// every array element the path went through.
+type matchSet []fieldMatch
+
+type fieldMatch struct {
+	value   interface{}
+	missing *missingFieldError // set if the rest of the path is missing in this element
 }
 
+func (set matchSet) values() []interface{} {
+	values := make([]interface{}, len(set))
+	for i, match := range set {
+		values[i] = match.value
+	}
+
+	return values
+}
+
+// resolveFieldValue looks up a field path like products[0].info.title in the
+// context. A path with a [*] wildcard resolves to a matchSet.
 func resolveFieldValue(fieldName string, context interface{}) (interface{}, error) {
-	re := regexp.MustCompile(`(\w+|\[\d+\])`)
-	matches := re.FindAllString(fieldName, -1)
+	segments := fieldPathPattern.FindAllString(fieldName, -1)
+	return resolvePath(fieldName, segments, context)
+}
+
+func resolvePath(fieldName string, segments []string, current interface{}) (interface{}, error) {
+	for i, segment := range segments {
+		if segment == "[*]" {
+			return resolveWildcard(fieldName, segments[i+1:], current)
+		}
 
-	var current interface{} = context
-	for _, match := range matches {
 		switch cur := current.(type) {
 		case map[string]interface{}:
-			current = cur[match]
+			value, ok := cur[segment]
+			if !ok {
+				return nil, &missingFieldError{reason: fmt.Sprintf("field '%s' is missing", fieldName)}
+			}
+
+			current = value
 		case []interface{}:
-			if !strings.HasPrefix(match, "[") || !strings.HasSuffix(match, "]") {
-				return nil, fmt.Errorf("invalid array index: %s", match)
+			if !strings.HasPrefix(segment, "[") || !strings.HasSuffix(segment, "]") {
+				return nil, fmt.Errorf("invalid array index: %s", segment)
 			}
 
-			value := match[1 : len(match)-1]
+			value := segment[1 : len(segment)-1]
 			index, err := strconv.Atoi(value)
-
-			if index < 0 || index >= len(cur) {
-				return nil, fmt.Errorf("array index out of range: %d", index)
+			if err != nil {
+				return nil, fmt.Errorf("invalid array index: %s", segment)
 			}
 
-			if err != nil {
-				return nil, fmt.Errorf("invalid array index: %s", match)
+			if index < 0 || index >= len(cur) {
+				return nil, &missingFieldError{reason: fmt.Sprintf("array index out of range: %d", index), outOfRange: true}
 			}
 
 			current = cur[index]
+		case nil:
+			return nil, &missingFieldError{reason: fmt.Sprintf("field '%s' is missing", fieldName)}
 		default:
-			return nil, fmt.Errorf("invalid field path: %s", match)
+			return nil, fmt.Errorf("invalid field path: %s", segment)
 		}
 	}
 
 	return current, nil
 }
+
+// resolveWildcard resolves the rest of a path in every element of an array.
+// Nested wildcards are flattened into a single set.
+func resolveWildcard(fieldName string, rest []string, current interface{}) (interface{}, error) {
+	list, ok := current.([]interface{})
+	if !ok {
+		if current == nil {
+			return nil, &missingFieldError{reason: fmt.Sprintf("field '%s' is missing", fieldName)}
+		}
+
+		return nil, fmt.Errorf("invalid field path: [*] needs an array, got %T", current)
+	}
+
+	set := matchSet{}
+	for _, element := range list {
+		value, err := resolvePath(fieldName, rest, element)
+
+		var missing *missingFieldError
+		if errors.As(err, &missing) {
+			set = append(set, fieldMatch{missing: missing})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if nested, ok := value.(matchSet); ok {
+			set = append(set, nested...)
+		} else {
+			set = append(set, fieldMatch{value: value})
+		}
+	}
+
+	return set, nil
+}
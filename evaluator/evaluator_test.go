@@ -1,7 +1,17 @@
 package evaluator
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alicavdar/logix/lexer"
 	"github.com/alicavdar/logix/parser"
@@ -17,6 +27,7 @@ func TestEvaluator(t *testing.T) {
 		name        string
 		input       string
 		context     map[string]interface{}
+		options     Options
 		expected    bool
 		expectError bool
 		errorMsg    string
@@ -29,6 +40,579 @@ func TestEvaluator(t *testing.T) {
 			},
 			expected: true,
 		},
+		{
+			name: "Integer context values are compared as numbers",
+			input: `
+price gt 100
+stock between 50 and 100
+quantity eq 3
+category_id in [1, 2, 3]
+`,
+			context: map[string]interface{}{
+				"price":       120,
+				"stock":       int64(75),
+				"quantity":    uint8(3),
+				"category_id": int32(2),
+			},
+			expected: true,
+		},
+		{
+			name: "Float32, json.Number and big numbers",
+			input: `
+ratio lt 1
+amount gte 10.5
+big_int gt 1000
+big_float lte 2
+`,
+			context: map[string]interface{}{
+				"ratio":     float32(0.5),
+				"amount":    json.Number("10.5"),
+				"big_int":   new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil),
+				"big_float": big.NewFloat(1.5),
+			},
+			expected: true,
+		},
+		{
+			name: "Integers beyond 2^53 are compared exactly",
+			input: `
+id neq other_id
+id not in [other_id]
+id gt other_id
+id between other_id and id
+big_id neq 9007199254740992
+json_id gt other_id
+`,
+			context: map[string]interface{}{
+				"id":       int64(9007199254740993),
+				"other_id": int64(9007199254740992),
+				"big_id":   new(big.Int).Add(big.NewInt(1<<53), big.NewInt(1)),
+				"json_id":  json.Number("9007199254740993"),
+			},
+			expected: true,
+		},
+		{
+			name: "Negative and fractional literals",
+			input: `
+temperature gt -5
+ratio lt 0.25
+population between 1e6 and 1_500_000
+`,
+			context: map[string]interface{}{
+				"temperature": -2,
+				"ratio":       0.2,
+				"population":  1200000,
+			},
+			expected: true,
+		},
+		{
+			name: "Escaped and alternatively quoted strings",
+			input: `
+payload contains '{"sku": "A-1"}'
+title eq "The \"Best\" Chair"
+notes startsWith "line one\n"
+path endsWith ` + "`\\tmp`" + `
+`,
+			context: map[string]interface{}{
+				"payload": `{"id": 1, "items": [{"sku": "A-1"}]}`,
+				"title":   `The "Best" Chair`,
+				"notes":   "line one\nline two",
+				"path":    `C:\tmp`,
+			},
+			expected: true,
+		},
+		{
+			name: "Unicode field names and values",
+			input: `
+ürünler[0].başlık eq "Çay bardağı"
+価格 lt 1000
+größe contains "ß"
+`,
+			context: map[string]interface{}{
+				"ürünler": []interface{}{
+					map[string]interface{}{"başlık": "Çay bardağı"},
+				},
+				"価格":    800,
+				"größe": "groß",
+			},
+			expected: true,
+		},
+		{
+			name: "Regex matches",
+			input: `
+sku matches "^SKU-[0-9]{4}$"
+code not matches "^TMP-"
+`,
+			context: map[string]interface{}{
+				"sku":  "SKU-1234",
+				"code": "PRD-9",
+			},
+			expected: true,
+		},
+		{
+			name:  "Regex does not match",
+			input: `sku matches "^SKU-[0-9]{4}$"`,
+			context: map[string]interface{}{
+				"sku": "SKU-12345",
+			},
+			expected: false,
+		},
+		{
+			name: "Case-insensitive string operators",
+			input: `
+status ieq "active"
+title icontains "straße"
+sku istartsWith "sku-"
+file iendsWith ".PDF"
+country iin ["tr", "de"]
+tag not icontains "deleted"
+code not iin ["x", "y"]
+`,
+			context: map[string]interface{}{
+				"status":  "Active",
+				"title":   "Hauptstraße 5",
+				"sku":     "SKU-1234",
+				"file":    "report.pdf",
+				"country": "DE",
+				"tag":     "featured",
+				"code":    "Z",
+			},
+			expected: true,
+		},
+		{
+			name:  "Case-insensitive comparison uses Unicode case folding",
+			input: `name ieq "ΣΊΣΥΦΟΣ"`,
+			context: map[string]interface{}{
+				"name": "σίσυφος",
+			},
+			expected: true,
+		},
+		{
+			name:  "Case-insensitive operators on non-strings",
+			input: `count ieq 3`,
+			context: map[string]interface{}{
+				"count": 3,
+			},
+			expected: true,
+		},
+		{
+			name:  "String operator with a non-string value",
+			input: `title contains 5`,
+			context: map[string]interface{}{
+				"title": "Hello",
+			},
+			expectError: true,
+			errorMsg:    "line 1, column 1: the value is not a string for 'contains' operator",
+		},
+		{
+			name:  "neq treats 120 and 120.0 as equal",
+			input: "price neq 120",
+			context: map[string]interface{}{
+				"price": uint(120),
+			},
+			expected: false,
+		},
+		{
+			name:  "Malformed json.Number",
+			input: "amount gt 1",
+			context: map[string]interface{}{
+				"amount": json.Number("ten"),
+			},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid JSON number 'ten'",
+		},
+		{
+			name:  "Numbers never equal strings",
+			input: `code eq "120"`,
+			context: map[string]interface{}{
+				"code": 120,
+			},
+			expected: false,
+		},
+		{
+			name: "Wildcard paths match if any element matches",
+			input: `
+items[*].sku eq "B"
+items[*].price gt 15
+items[*].tags[*] eq "sale"
+items[*].sku not in ["A", "B"]
+`,
+			context:  wildcardContext(),
+			expected: true,
+		},
+		{
+			name:     "Wildcard path without a matching element",
+			input:    `items[*].sku eq "X"`,
+			context:  wildcardContext(),
+			expected: false,
+		},
+		{
+			name: "Explicit quantifiers",
+			input: `
+any items[*].price gt 15
+all items[*].price gt 5
+none items[*].sku eq "X"
+all items[*].tags[*] neq "deleted"
+all scores gte 1
+none scores gt 10
+`,
+			context:  wildcardContext(),
+			expected: true,
+		},
+		{
+			name:     "all fails when one element does not match",
+			input:    `all items[*].price gt 10`,
+			context:  wildcardContext(),
+			expected: false,
+		},
+		{
+			name:     "none fails when one element matches",
+			input:    `none items[*].tags[*] eq "new"`,
+			context:  wildcardContext(),
+			expected: false,
+		},
+		{
+			name: "Quantifiers over an empty array",
+			input: `
+all empty[*].price gt 10
+none empty[*].price gt 10
+`,
+			context:  wildcardContext(),
+			expected: true,
+		},
+		{
+			name:     "any over an empty array",
+			input:    `any empty gt 10`,
+			context:  wildcardContext(),
+			expected: false,
+		},
+		{
+			name: "Missing fields inside wildcards",
+			input: `
+items[*].discount exists
+all items[*].sku exists
+items[*].discount eq nil
+`,
+			context:  wildcardContext(),
+			expected: true,
+		},
+		{
+			name:     "Missing field inside a wildcard with the false policy",
+			input:    `items[*].discount eq nil`,
+			context:  wildcardContext(),
+			options:  Options{MissingField: MissingFieldFalse},
+			expected: false,
+		},
+		{
+			name:        "Missing field inside a wildcard with the error policy",
+			input:       `items[*].discount eq 5`,
+			context:     wildcardContext(),
+			options:     Options{MissingField: MissingFieldError},
+			expectError: true,
+			errorMsg:    "line 1, column 1: field 'items[*].discount' is missing",
+		},
+		{
+			name:        "Quantifier over a non-array",
+			input:       `all title eq "x"`,
+			context:     map[string]interface{}{"title": "x"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: the field value is not an array for quantifier 'all'",
+		},
+		{
+			name:        "Wildcard over a non-array",
+			input:       `title[*] eq "x"`,
+			context:     map[string]interface{}{"title": "x"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid field path: [*] needs an array, got string",
+		},
+		{
+			name: "contains on an array field",
+			input: `
+roles contains "admin"
+roles not contains "guest"
+scores contains 5
+`,
+			context:  collectionContext(),
+			expected: true,
+		},
+		{
+			name: "Collection operators",
+			input: `
+roles containsAny ["guest", "editor"]
+roles not containsAny ["guest"]
+roles containsAll ["admin", "editor"]
+roles not containsAll ["admin", "guest"]
+roles subsetOf ["admin", "editor", "viewer"]
+roles not subsetOf ["admin"]
+permissions intersects ["write", "delete"]
+permissions not intersects ["delete"]
+scores containsAll [1, 10]
+empty subsetOf ["admin"]
+empty not containsAny ["admin"]
+`,
+			context:  collectionContext(),
+			expected: true,
+		},
+		{
+			name:     "Collection operators accept typed slices",
+			input:    `permissions containsAll ["read"]`,
+			context:  map[string]interface{}{"permissions": []string{"read", "write"}},
+			expected: true,
+		},
+		{
+			name:     "containsAll fails when an element is missing",
+			input:    `roles containsAll ["admin", "owner"]`,
+			context:  collectionContext(),
+			expected: false,
+		},
+		{
+			name: "Length of strings, arrays and maps",
+			input: `
+len(items) eq 3
+len(items[*]) eq 3
+len(name) eq 5
+len(attributes) lte 2
+len(missing) eq 0
+`,
+			context: map[string]interface{}{
+				"items":      []interface{}{1, 2, 3},
+				"name":       "Zoë ✓",
+				"attributes": map[string]interface{}{"color": "red"},
+			},
+			expected: true,
+		},
+		{
+			name:     "Length of a typed slice",
+			input:    `len(tags) gt 1`,
+			context:  map[string]interface{}{"tags": []string{"a", "b"}},
+			expected: true,
+		},
+		{
+			name:        "Length of a number",
+			input:       `len(price) gt 1`,
+			context:     map[string]interface{}{"price": 10.0},
+			expectError: true,
+			errorMsg:    "line 1, column 1: function 'len': expected a string, an array or a map, got float64",
+		},
+		{
+			name:        "Length of a missing field with the error policy",
+			input:       `len(items) gt 1`,
+			context:     map[string]interface{}{},
+			options:     Options{MissingField: MissingFieldError},
+			expectError: true,
+			errorMsg:    "line 1, column 1: field 'items' is missing",
+		},
+		{
+			name:        "Unknown function",
+			input:       `size(items) gt 1`,
+			context:     map[string]interface{}{"items": []interface{}{}},
+			expectError: true,
+			errorMsg:    "line 1, column 1: unknown function 'size'",
+		},
+		{
+			name: "isEmpty and not isEmpty",
+			input: `
+comment not isEmpty
+tags isEmpty
+attributes isEmpty
+note isEmpty
+missing isEmpty
+`,
+			context: map[string]interface{}{
+				"comment":    "Great",
+				"tags":       []interface{}{},
+				"attributes": map[string]interface{}{},
+				"note":       "",
+			},
+			expected: true,
+		},
+		{
+			name:        "isEmpty on a number",
+			input:       `price isEmpty`,
+			context:     map[string]interface{}{"price": 0.0},
+			expectError: true,
+			errorMsg:    "line 1, column 1: the field value is not a string, an array or a map for 'isEmpty' operator",
+		},
+		{
+			name: "Field-to-field comparisons",
+			input: `
+discount_price lt list_price
+list_price neq discount_price
+end_date gt start_date
+stock between limits.min and limits.max
+role in allowed_roles
+role not in [banned_role, "guest"]
+items[*].sku eq featured_sku
+name eq owner.name
+`,
+			context: map[string]interface{}{
+				"discount_price": 80.0,
+				"list_price":     100,
+				"start_date":     20240101,
+				"end_date":       20240301,
+				"stock":          50.0,
+				"limits":         map[string]interface{}{"min": 10.0, "max": 100.0},
+				"role":           "editor",
+				"allowed_roles":  []interface{}{"admin", "editor"},
+				"banned_role":    "viewer",
+				"items": []interface{}{
+					map[string]interface{}{"sku": "A"},
+					map[string]interface{}{"sku": "B"},
+				},
+				"featured_sku": "B",
+				"name":         "Ada",
+				"owner":        map[string]interface{}{"name": "Ada"},
+			},
+			expected: true,
+		},
+		{
+			name:        "Field-to-field comparison of different types",
+			input:       `price lt label`,
+			context:     map[string]interface{}{"price": 10.0, "label": "cheap"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid types for numeric comparison: float64 and string",
+		},
+		{
+			name:     "Missing referenced field is nil by default",
+			input:    `discount eq coupon`,
+			context:  map[string]interface{}{"discount": nil},
+			expected: true,
+		},
+		{
+			name:     "Missing referenced field with the false policy",
+			input:    `discount not in [coupon]`,
+			context:  map[string]interface{}{"discount": 5.0},
+			options:  Options{MissingField: MissingFieldFalse},
+			expected: false,
+		},
+		{
+			name:        "Missing referenced field with the error policy",
+			input:       `price lt max_price`,
+			context:     map[string]interface{}{"price": 5.0},
+			options:     Options{MissingField: MissingFieldError},
+			expectError: true,
+			errorMsg:    "line 1, column 1: field 'max_price' is missing",
+		},
+		{
+			name: "Arithmetic expressions",
+			input: `
+price * quantity gt 500
+total - refunded gte 100
+price + quantity * 2 eq 130
+(price + quantity) * 2 eq 240
+total / 4 eq 62.5
+quantity % 7 eq 3
+-refunded lt 0
+total gte refunded * 2 + 10
+price between quantity * 10 and total
+`,
+			context: map[string]interface{}{
+				"price":    110.0,
+				"quantity": 10,
+				"total":    250,
+				"refunded": int64(100),
+			},
+			expected: true,
+		},
+		{
+			name: "Arithmetic that starts with a literal",
+			input: `
+2 * quantity gt 5
+100 - quantity gt 5
+-2 * quantity lt 0
+7d gt timeout
+"draft" eq status
+`,
+			context: map[string]interface{}{
+				"quantity": 10,
+				"timeout":  3600,
+				"status":   "draft",
+			},
+			expected: true,
+		},
+		{
+			name:        "Division by zero",
+			input:       `total / count gt 1`,
+			context:     map[string]interface{}{"total": 10.0, "count": 0},
+			expectError: true,
+			errorMsg:    "line 1, column 1: division by zero in 'total / count'",
+		},
+		{
+			name:        "Modulo by zero",
+			input:       `total gt count % 0`,
+			context:     map[string]interface{}{"total": 10.0, "count": 3},
+			expectError: true,
+			errorMsg:    "line 1, column 1: division by zero in 'count % 0'",
+		},
+		{
+			name:        "Arithmetic on a string",
+			input:       `price * label gt 1`,
+			context:     map[string]interface{}{"price": 10.0, "label": "x"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid types for '*': float64 and string",
+		},
+		{
+			name:     "Arithmetic with a missing field and the false policy",
+			input:    `price * quantity not in [1]`,
+			context:  map[string]interface{}{"price": 10.0},
+			options:  Options{MissingField: MissingFieldFalse},
+			expected: false,
+		},
+		{
+			name: "Built-in functions",
+			input: `
+lower(email) endsWith "@acme.com"
+upper(code) eq "AB-1"
+trim(name) eq "Ada"
+abs(delta) lt 5
+round(score, 2) eq 4.25
+round(score) eq 4
+floor(score) eq 4
+ceil(score) eq 5
+min(a, b, 3) eq 1
+max(items[*].price) eq 30
+max(a, b) eq 2
+substr(sku, 0, 3) eq "SKU"
+substr(sku, 4) eq "ü42"
+substr(sku, 10, 2) eq ""
+len(trim(name)) eq 3
+lower(email) eq lower(email_upper)
+now() neq nil
+`,
+			context: map[string]interface{}{
+				"email":       "Ada@ACME.com",
+				"email_upper": "ADA@ACME.COM",
+				"code":        "ab-1",
+				"name":        "  Ada ",
+				"delta":       -3,
+				"score":       4.2451,
+				"a":           1,
+				"b":           2.0,
+				"items":       wildcardContext()["items"],
+				"sku":         "SKU-ü42",
+			},
+			expected: true,
+		},
+		{
+			name:        "Function with an argument of the wrong type",
+			input:       `lower(price) eq "x"`,
+			context:     map[string]interface{}{"price": 10.0},
+			expectError: true,
+			errorMsg:    "line 1, column 1: function 'lower': argument 1: expected a string, got float64",
+		},
+		{
+			name:        "Function with a fractional count",
+			input:       `round(price, 1.5) eq 1`,
+			context:     map[string]interface{}{"price": 10.0},
+			expectError: true,
+			errorMsg:    "line 1, column 1: function 'round': expected a whole number, got 1.5",
+		},
+		{
+			name:        "Collection operator on a non-array",
+			input:       `title containsAny ["x"]`,
+			context:     map[string]interface{}{"title": "x"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: the field value is not an array for 'containsAny' operator",
+		},
 		{
 			name:  "Invalid operator error",
 			input: "age xyz 30",
@@ -36,7 +620,7 @@ func TestEvaluator(t *testing.T) {
 				"age": 30.0,
 			},
 			expectError: true,
-			errorMsg:    "unknown operator 'xyz'",
+			errorMsg:    "line 1, column 1: unknown operator 'xyz'",
 		},
 		{
 			name:  "Invalid numeric comparison types",
@@ -45,7 +629,7 @@ func TestEvaluator(t *testing.T) {
 				"age": 30.0,
 			},
 			expectError: true,
-			errorMsg:    "invalid types for numeric comparison: float64 and string",
+			errorMsg:    "line 1, column 1: invalid types for numeric comparison: float64 and string",
 		},
 		{
 			name: "Group with 'and' logic",
@@ -74,21 +658,152 @@ group or
 			expected: false,
 		},
 		{
-			name: "Field resolution with out of range index",
+			name: "'or' group stops at the first passing child",
 			input: `
-products[100].category.name eq "Electronics"
+group or
+	age gt 18
+	age lt "twenty"
 `,
 			context: map[string]interface{}{
-				"products": []interface{}{
-					map[string]interface{}{
-						"category": map[string]interface{}{
-							"name": "Electronics",
-						},
-					},
-				},
+				"age": 25.0,
 			},
-			expectError: true,
-			errorMsg:    "array index out of range: 100",
+			expected: true,
+		},
+		{
+			name: "'and' group stops at the first failing child",
+			input: `
+group and
+	age gt 30
+	age lt "twenty"
+`,
+			context: map[string]interface{}{
+				"age": 25.0,
+			},
+			expected: false,
+		},
+		{
+			name: "Field resolution with out of range index",
+			input: `
+products[100].category.name eq "Electronics"
+`,
+			context: map[string]interface{}{
+				"products": []interface{}{
+					map[string]interface{}{
+						"category": map[string]interface{}{
+							"name": "Electronics",
+						},
+					},
+				},
+			},
+			expectError: true,
+			errorMsg:    "line 2, column 1: array index out of range: 100",
+		},
+		{
+			name: "Out of range index with the false policy",
+			input: `
+products[100].category.name eq nil
+`,
+			context: map[string]interface{}{
+				"products": []interface{}{},
+			},
+			options:  Options{MissingField: MissingFieldFalse},
+			expected: false,
+		},
+		{
+			name:  "Missing field is nil by default",
+			input: "discount eq nil",
+			context: map[string]interface{}{
+				"price": 10,
+			},
+			expected: true,
+		},
+		{
+			name:  "Missing field with the error policy",
+			input: "discount eq nil",
+			context: map[string]interface{}{
+				"price": 10,
+			},
+			options:     Options{MissingField: MissingFieldError},
+			expectError: true,
+			errorMsg:    "line 1, column 1: field 'discount' is missing",
+		},
+		{
+			name:  "Missing parent with the error policy",
+			input: "info.title eq nil",
+			context: map[string]interface{}{
+				"info": nil,
+			},
+			options:     Options{MissingField: MissingFieldError},
+			expectError: true,
+			errorMsg:    "line 1, column 1: field 'info.title' is missing",
+		},
+		{
+			name: "Missing field with the false policy",
+			input: `
+group or
+	price gt 5
+	title not contains "x"
+	stock eq 3
+`,
+			context: map[string]interface{}{
+				"stock": 3,
+			},
+			options:  Options{MissingField: MissingFieldFalse},
+			expected: true,
+		},
+		{
+			name:  "Negated condition on a missing field with the false policy",
+			input: `title not contains "x"`,
+			context: map[string]interface{}{
+				"price": 10,
+			},
+			options:  Options{MissingField: MissingFieldFalse},
+			expected: false,
+		},
+		{
+			name: "exists and not exists",
+			input: `
+discount exists
+products[0].info.title exists
+coupon not exists
+products[3] not exists
+info.title not exists
+`,
+			context: map[string]interface{}{
+				"discount": nil,
+				"products": []interface{}{
+					map[string]interface{}{
+						"info": map[string]interface{}{"title": "Phone"},
+					},
+				},
+			},
+			options:  Options{MissingField: MissingFieldError},
+			expected: true,
+		},
+		{
+			name:  "exists fails for a missing field",
+			input: `coupon exists`,
+			context: map[string]interface{}{
+				"price": 10,
+			},
+			expected: false,
+		},
+		{
+			name: "Error inside a nested group points at the failing line",
+			input: `
+group and
+	age gt 18
+	group or
+		title eq "Hello"
+		name contains "J"
+`,
+			context: map[string]interface{}{
+				"age":   25.0,
+				"title": "Hi",
+				"name":  10.0,
+			},
+			expectError: true,
+			errorMsg:    "line 6, column 3: the field value is not a string for 'contains' operator",
 		},
 		{
 			name: "Field resolution with valid index",
@@ -106,12 +821,269 @@ products[0].category.name eq "Electronics"
 			},
 			expected: true,
 		},
+		{
+			name: "RFC 3339 strings are compared as times",
+			input: `
+created_at gt "2024-01-31T08:59:59Z"
+created_at lte 2024-01-31T12:00:00+02:00
+`,
+			context:  map[string]interface{}{"created_at": "2024-01-31T10:00:00+01:00"},
+			expected: true,
+		},
+		{
+			name: "Before and after with time.Time values",
+			input: `
+created_at after 2024-01-01
+created_at before expires_at
+expires_at not before 2024-06-01
+`,
+			context:  temporalContext(),
+			expected: true,
+		},
+		{
+			name:     "Times in different zones are equal at the same instant",
+			input:    `created_at eq "2024-03-10T11:30:00+02:00"`,
+			context:  temporalContext(),
+			expected: true,
+		},
+		{
+			name:     "Between on times includes the bounds",
+			input:    `created_at between 2024-03-10T09:30:00Z and 2024-03-31`,
+			context:  temporalContext(),
+			expected: true,
+		},
+		{
+			name: "Before, after and now are still field names",
+			input: `
+now eq 5
+before eq 1
+after not in [1, 2]
+created_at before after
+`,
+			context:  map[string]interface{}{"now": 5, "before": 1, "after": "2024-06-01", "created_at": "2024-03-10"},
+			expected: true,
+		},
+		{
+			name: "Quantifiers, exists and isEmpty are still field names",
+			input: `
+all eq 1
+any not in [3, 4]
+none exists
+exists eq true
+isEmpty eq "no"
+any tags isEmpty
+`,
+			context: map[string]interface{}{
+				"all": 1, "any": 2, "none": 3, "exists": true, "isEmpty": "no",
+				"tags": []interface{}{"", "x"},
+			},
+			expected: true,
+		},
+		{
+			name: "Relative times use the clock",
+			input: `
+created_at after now() - 7d
+expires_at after now() + 30d
+now() - created_at eq now() - 2024-03-10T09:30:00Z
+`,
+			context:  temporalContext(),
+			options:  Options{Clock: fixedClock},
+			expected: true,
+		},
+		{
+			name:     "Relative times outside the window",
+			input:    `created_at after now() - 1d`,
+			context:  temporalContext(),
+			options:  Options{Clock: fixedClock},
+			expected: false,
+		},
+		{
+			name:        "Time comparison with a value that is not a time",
+			input:       `name before 2024-01-01`,
+			context:     map[string]interface{}{"name": "bob"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid types for time comparison: string and time.Time",
+		},
+		{
+			name: "Durations compare with time.Duration and numeric seconds",
+			input: `
+session_length gt 45m
+idle_seconds lt 5m
+idle_seconds eq 2m30s
+timeout in [500ms, 1s]
+session_length between 45m and 1h
+`,
+			context: map[string]interface{}{
+				"session_length": 50 * time.Minute,
+				"idle_seconds":   150,
+				"timeout":        time.Second,
+			},
+			expected: true,
+		},
+		{
+			name: "Duration arithmetic",
+			input: `
+session_length + 10m eq 1h
+session_length * 2 gt 1h30m
+session_length / 5m eq 10
+-session_length lt 0s
+session_length % 15m eq 5m
+expires_at - created_at gt 80d
+`,
+			context: map[string]interface{}{
+				"session_length": 50 * time.Minute,
+				"created_at":     time.Date(2024, 3, 10, 9, 30, 0, 0, time.UTC),
+				"expires_at":     "2024-06-01T00:00:00Z",
+			},
+			expected: true,
+		},
+		{
+			name:        "Duration comparison with a string",
+			input:       `session_length gt 45m`,
+			context:     map[string]interface{}{"session_length": "50m"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid types for duration comparison: string and time.Duration",
+		},
+		{
+			name:        "Duration in seconds out of range",
+			input:       `session_length gt 45m`,
+			context:     map[string]interface{}{"session_length": 1e11},
+			expectError: true,
+			errorMsg:    "line 1, column 1: duration of 1e+11 seconds is out of range",
+		},
+		{
+			name:        "Duration arithmetic out of range",
+			input:       `session_length * 1e12 gt 45m`,
+			context:     map[string]interface{}{"session_length": time.Hour},
+			expectError: true,
+			errorMsg:    "line 1, column 1: duration is out of range in 'session_length * 1e+12'",
+		},
+		{
+			name:        "Adding a number to a duration",
+			input:       `session_length + 5 gt 1h`,
+			context:     map[string]interface{}{"session_length": 50 * time.Minute},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid types for '+': time.Duration and float64",
+		},
+		{
+			name: "Versions are compared by SemVer precedence",
+			input: `
+app_version gte version("2.10.0")
+app_version lt version("2.10.1-rc.1")
+beta_version lt version("2.10.0")
+beta_version gt version("2.10.0-alpha.10")
+app_version between version("2.9.0") and version("3.0.0")
+app_version eq version("2.10.0+build.7")
+version(beta_version) not in [version("2.10.0"), version("2.10.0-beta.3")]
+`,
+			context: map[string]interface{}{
+				"app_version":  "2.10.0",
+				"beta_version": "2.10.0-beta.2",
+			},
+			expected: true,
+		},
+		{
+			name:     "Version values in the context",
+			input:    `app_version lt version("1.10.0")`,
+			context:  map[string]interface{}{"app_version": Version{Major: 1, Minor: 9, Patch: 30}},
+			expected: true,
+		},
+		{
+			name:        "Invalid version in the context",
+			input:       `app_version gte version("2.10.0")`,
+			context:     map[string]interface{}{"app_version": "2.10"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid version '2.10'",
+		},
+		{
+			name: "IP addresses and networks",
+			input: `
+client_ip inCidr ["10.0.0.0/8", "192.168.0.0/16"]
+client_ip not inCidr ["10.1.0.0/16"]
+client_ip isPrivate
+client_ip not isLoopback
+ipv6_ip inCidr ["2001:db8::/32"]
+ipv6_ip not isPrivate
+mapped_ip inCidr office_ranges
+mapped_ip eq ip("192.168.10.20")
+ip(mapped_ip) in [ip("10.0.0.1"), ip("192.168.10.20")]
+local_ip isLoopback
+`,
+			context: map[string]interface{}{
+				"client_ip":     "10.2.3.4",
+				"ipv6_ip":       "2001:db8::1",
+				"mapped_ip":     "::ffff:192.168.10.20",
+				"local_ip":      net.ParseIP("::1"),
+				"office_ranges": []interface{}{"172.16.0.0/12", netip.MustParsePrefix("192.168.10.0/24")},
+			},
+			expected: true,
+		},
+		{
+			name:        "IP operator on a value that is not an address",
+			input:       `client_ip isPrivate`,
+			context:     map[string]interface{}{"client_ip": "10.2.3"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: the field value is not an IP address for 'isPrivate' operator",
+		},
+		{
+			name:        "Invalid prefix in the context",
+			input:       `client_ip inCidr ranges`,
+			context:     map[string]interface{}{"client_ip": "10.2.3.4", "ranges": []interface{}{"10.0.0.0"}},
+			expectError: true,
+			errorMsg:    `line 1, column 1: invalid prefix for 'inCidr': netip.ParsePrefix("10.0.0.0"): no '/'`,
+		},
+		{
+			name: "Distance from a point",
+			input: `
+paris withinRadius [51.5074, -0.1278, 345]
+paris not withinRadius [51.5074, -0.1278, 340]
+courier withinRadius [warehouse.lat, warehouse.lon, 2.5]
+courier not withinRadius [warehouse.lat, warehouse.lon, 2.4]
+`,
+			context: map[string]interface{}{
+				"paris":     []interface{}{48.8566, 2.3522},
+				"courier":   map[string]interface{}{"latitude": 52.5163, "lng": 13.3777},
+				"warehouse": map[string]interface{}{"lat": 52.5219, "lon": 13.4132},
+			},
+			expected: true,
+		},
+		{
+			name: "Point in a concave polygon",
+			input: `
+inside insidePolygon [[0, 0], [0, 4], [2, 4], [2, 2], [4, 2], [4, 0]]
+notch not insidePolygon [[0, 0], [0, 4], [2, 4], [2, 2], [4, 2], [4, 0]]
+far not insidePolygon [[0, 0], [0, 4], [2, 4], [2, 2], [4, 2], [4, 0]]
+`,
+			context: map[string]interface{}{
+				"inside": []float64{1, 3},
+				"notch":  []float64{3, 3},
+				"far":    map[string]interface{}{"lat": 10, "lon": 10},
+			},
+			expected: true,
+		},
+		{
+			name:        "Geo operator on a value that is not a location",
+			input:       `courier withinRadius [52.52, 13.405, 5]`,
+			context:     map[string]interface{}{"courier": "Berlin"},
+			expectError: true,
+			errorMsg:    "line 1, column 1: the field value is not a location for 'withinRadius' operator",
+		},
+		{
+			name:        "Adding a number to a time",
+			input:       `created_at + 1 gt 2024-01-01`,
+			context:     temporalContext(),
+			expectError: true,
+			errorMsg:    "line 1, column 1: invalid types for '+': time.Time and float64",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := newTestParser(tt.input)
-			result, err := Evaluate(p, tt.context)
+			var result bool
+			nodes, err := newTestParser(tt.input).Parse()
+			if err == nil {
+				result, err = NewProgram(nodes, tt.options).Evaluate(tt.context)
+			}
 
 			if tt.expectError {
 				if err == nil {
@@ -130,3 +1102,695 @@ products[0].category.name eq "Electronics"
 		})
 	}
 }
+
+func wildcardContext() map[string]interface{} {
+	return map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"sku": "A", "price": 10, "tags": []interface{}{"new"}},
+			map[string]interface{}{"sku": "B", "price": 20, "tags": []interface{}{"sale", "new"}, "discount": 5},
+			map[string]interface{}{"sku": "C", "price": 30, "tags": []interface{}{}},
+		},
+		"scores": []interface{}{1, 5, 10},
+		"empty":  []interface{}{},
+	}
+}
+
+func temporalContext() map[string]interface{} {
+	return map[string]interface{}{
+		"created_at": time.Date(2024, 3, 10, 9, 30, 0, 0, time.UTC),
+		"expires_at": "2024-06-01T00:00:00Z",
+	}
+}
+
+// fixedClock is five days after created_at in temporalContext.
+func fixedClock() time.Time {
+	return time.Date(2024, 3, 15, 9, 30, 0, 0, time.UTC)
+}
+
+func collectionContext() map[string]interface{} {
+	return map[string]interface{}{
+		"roles":       []interface{}{"admin", "editor"},
+		"permissions": []interface{}{"read", "write"},
+		"scores":      []interface{}{1.0, 5.0, 10.0},
+		"empty":       []interface{}{},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(items) gt 1`, ""},
+		{`size(items) gt 1`, "line 1, column 1: unknown function 'size'"},
+		{`price gt max()`, "line 1, column 10: function 'max' expects at least 1 argument, got 0"},
+		{`round(a, 2, 3) eq 1`, "line 1, column 1: function 'round' expects 1 to 2 arguments, got 3"},
+		{`lower(a, b) eq 1`, "line 1, column 1: function 'lower' expects 1 argument, got 2"},
+		{`abs(floor()) eq 1`, "line 1, column 5: function 'floor' expects 1 argument, got 0"},
+		{`lower(5) eq "5"`, "line 1, column 1: function 'lower' expects a string as argument 1, got a number"},
+		{`substr(name, "1") eq "a"`, "line 1, column 1: function 'substr' expects a number as argument 2, got a string"},
+		{`abs(upper(code)) eq 1`, "line 1, column 1: function 'abs' expects a number as argument 1, got a string"},
+		{`price * lower(code) eq 1`, "line 1, column 9: operator '*' expects numbers, got a string"},
+		{`price eq -"a"`, "line 1, column 11: operator '-' expects numbers, got a string"},
+		{`now() - 1d after created_at`, ""},
+		{`created_at after now - 7d`, "line 1, column 18: 'now' is a field here, use now() for the current time"},
+		{`7d + now lt expires_at`, "line 1, column 6: 'now' is a field here, use now() for the current time"},
+		{`now - elapsed gt 1`, ""},
+		{`now() * 2 gt 1`, "line 1, column 1: operator '*' expects numbers, got a time"},
+		{`price eq -now()`, "line 1, column 11: operator '-' expects numbers, got a time"},
+		{`-(now() - created_at) * 2 lt 7d`, ""},
+		{`abs(7d + created_at) gt 1`, ""},
+		{`lower(5m) eq "5m"`, "line 1, column 1: function 'lower' expects a string as argument 1, got a duration"},
+		{`abs(now() - 2024-01-01) gt 1`, "line 1, column 1: function 'abs' expects a number as argument 1, got a duration"},
+		{`app_version gte version("2.10")`, "line 1, column 17: function 'version': invalid version '2.10'"},
+		{`app_version gte version(2)`, "line 1, column 17: function 'version' expects a string as argument 1, got a number"},
+		{`client_ip eq ip("10.0.0.256")`, "line 1, column 14: function 'ip': invalid IP address '10.0.0.256'"},
+		{"group or\n    now(1) gt 1\n    a eq -floor(b, c)", "line 2, column 5: function 'now' expects 0 arguments, got 1\nline 3, column 11: function 'floor' expects 1 argument, got 2"},
+	}
+
+	for _, tt := range tests {
+		nodes, err := newTestParser(tt.input).Parse()
+		if err != nil {
+			t.Fatalf("Did not expect a parse error but got: %v", err)
+		}
+
+		err = Check(nodes)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%s: did not expect an error but got: %v", tt.input, err)
+			}
+		} else if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestVersionPrecedence(t *testing.T) {
+	// The example from the SemVer 2.0 specification, in ascending order
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, err := ParseVersion(ordered[i])
+			if err != nil {
+				t.Fatalf("Did not expect an error but got: %v", err)
+			}
+
+			b, err := ParseVersion(ordered[j])
+			if err != nil {
+				t.Fatalf("Did not expect an error but got: %v", err)
+			}
+
+			if expected := cmp.Compare(i, j); a.Compare(b) != expected {
+				t.Errorf("Expected %s compared with %s to be %d, got %d", a, b, expected, a.Compare(b))
+			}
+		}
+	}
+
+	for _, invalid := range []string{"1.0", "1.0.0.0", "01.0.0", "1.0.0-", "1.0.0-01", "1.0.0+", "1.0.0-beta..1", "v1.0.0", "1.0.0-ß"} {
+		if _, err := ParseVersion(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+
+	v, err := ParseVersion("1.0.0-rc.1+build.5")
+	if err != nil || v.String() != "1.0.0-rc.1+build.5" {
+		t.Errorf("Expected 1.0.0-rc.1+build.5, got %v, %v", v, err)
+	}
+}
+
+func TestEnvOperators(t *testing.T) {
+	env := NewEnv()
+
+	err := env.RegisterOperator("matchesSku", Operator{
+		Arity:     1,
+		Negatable: true,
+		Eval: func(field interface{}, values []interface{}) (bool, error) {
+			sku, ok := field.(string)
+			if !ok {
+				return false, fmt.Errorf("expected a string, got %T", field)
+			}
+
+			prefix, _ := values[0].(string)
+			return strings.HasPrefix(sku, strings.TrimSuffix(prefix, "*")), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	err = env.RegisterOperator("isEven", Operator{
+		Eval: func(field interface{}, values []interface{}) (bool, error) {
+			n, _ := field.(float64)
+			return int(n)%2 == 0, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	err = env.RegisterOperator("inRange", Operator{
+		Arity: 2,
+		Eval: func(field interface{}, values []interface{}) (bool, error) {
+			n, _ := field.(float64)
+			return n >= values[0].(float64) && n <= values[1].(float64), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	err = env.RegisterOperator("oneOf", Operator{
+		Arity: -1,
+		Eval: func(field interface{}, values []interface{}) (bool, error) {
+			for _, value := range values {
+				if field == value {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	input := `
+sku matchesSku "A-*"
+sku not matchesSku "B-*"
+count isEven
+count inRange [0, max_count]
+region oneOf regions
+`
+	p := newTestParser(input)
+	env.DeclareOperators(p)
+
+	nodes, err := p.Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	program, err := Compile(nodes, Options{Env: env})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	context := map[string]interface{}{"sku": "A-100", "count": 4.0, "max_count": 10.0, "region": "eu", "regions": []interface{}{"us", "eu"}}
+	if result, err := program.Evaluate(context); err != nil || !result {
+		t.Errorf("Expected true, got %v, %v", result, err)
+	}
+
+	context["count"] = 3.0
+	if result, err := program.Evaluate(context); err != nil || result {
+		t.Errorf("Expected false, got %v, %v", result, err)
+	}
+
+	context["sku"] = 5.0
+	_, err = program.Evaluate(context)
+	if err == nil || err.Error() != "line 2, column 1: operator 'matchesSku': expected a string, got float64" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Rules compiled without the Env do not know its operators
+	if _, err := Compile(nodes, Options{}); err == nil || !strings.Contains(err.Error(), "unknown operator 'matchesSku'") {
+		t.Errorf("Expected an unknown operator error, got %v", err)
+	}
+}
+
+func TestEnvCheck(t *testing.T) {
+	env := NewEnv()
+	eval := func(field interface{}, values []interface{}) (bool, error) { return true, nil }
+
+	if err := env.RegisterOperator("inRange", Operator{Arity: 2, Eval: eval}); err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	nodes, err := newTestParser("count inRange 5\ncount unknownOp 1").Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	expected := "line 1, column 1: operator 'inRange' expects 2 values, got 1\nline 2, column 1: unknown operator 'unknownOp'"
+	if err := env.Check(nodes); err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestEnvFunctions(t *testing.T) {
+	env := NewEnv()
+
+	type key struct{}
+	err := env.RegisterFunction("riskScore", Function{
+		Params: []Type{TypeString},
+		Result: TypeNumber,
+		Call: func(ctx context.Context, args []interface{}) (interface{}, error) {
+			scores, _ := ctx.Value(key{}).(map[string]int)
+			return scores[args[0].(string)], nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	err = env.RegisterFunction("sum", Function{
+		Params:   []Type{TypeNumber},
+		Variadic: true,
+		Result:   TypeNumber,
+		Call: func(ctx context.Context, args []interface{}) (interface{}, error) {
+			total := 0.0
+			for _, arg := range args {
+				total += arg.(float64)
+			}
+
+			return total, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	err = env.RegisterFunction("broken", Function{
+		Result: TypeString,
+		Call: func(ctx context.Context, args []interface{}) (interface{}, error) {
+			return 1, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	compile := func(input string) *Program {
+		t.Helper()
+
+		nodes, err := newTestParser(input).Parse()
+		if err != nil {
+			t.Fatalf("Did not expect an error but got: %v", err)
+		}
+
+		program, err := Compile(nodes, Options{Env: env})
+		if err != nil {
+			t.Fatalf("Did not expect an error but got: %v", err)
+		}
+
+		return program
+	}
+
+	program := compile(`
+riskScore(user_id) gt 50
+sum() eq 0
+sum(a, b, 3) eq 6
+sum(a) + riskScore(user_id) eq 81
+`)
+
+	ctx := context.WithValue(context.Background(), key{}, map[string]int{"u1": 80})
+	data := map[string]interface{}{"user_id": "u1", "a": 1, "b": int64(2)}
+	if result, err := program.EvaluateWithContext(ctx, data); err != nil || !result {
+		t.Errorf("Expected true, got %v, %v", result, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := program.EvaluateWithContext(cancelled, data); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	data["user_id"] = 10
+	_, err = program.Evaluate(data)
+	if err == nil || err.Error() != "line 2, column 1: function 'riskScore': argument 1: expected a string, got int" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	_, err = compile(`broken() eq "x"`).Evaluate(data)
+	if err == nil || err.Error() != "line 1, column 1: function 'broken': result: expected a string, got int" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	nodes, err := newTestParser(`riskScore(42) gt 1` + "\n" + `sum(a, "b") gt 1`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	expected := "line 1, column 1: function 'riskScore' expects a string as argument 1, got a number\nline 2, column 1: function 'sum' expects a number as argument 2, got a string"
+	if _, err := Compile(nodes, Options{Env: env}); err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestRegisterFunctionErrors(t *testing.T) {
+	call := func(ctx context.Context, args []interface{}) (interface{}, error) { return nil, nil }
+
+	tests := []struct {
+		name     string
+		fn       Function
+		expected string
+	}{
+		{"in", Function{Call: call}, "function 'in' is a built-in keyword"},
+		{"lower", Function{Call: call}, "function 'lower' is a built-in function"},
+		{"a[0]", Function{Call: call}, "invalid function name 'a[0]'"},
+		{"noCall", Function{}, "function 'noCall' has no Call function"},
+		{"spread", Function{Variadic: true, Call: call}, "variadic function 'spread' needs at least one parameter"},
+		{"twice", Function{Call: call}, "function 'twice' is already registered"},
+	}
+
+	env := NewEnv()
+	if err := env.RegisterFunction("twice", Function{Call: call}); err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	for _, tt := range tests {
+		err := env.RegisterFunction(tt.name, tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestRegisterOperatorErrors(t *testing.T) {
+	eval := func(field interface{}, values []interface{}) (bool, error) { return true, nil }
+
+	tests := []struct {
+		name     string
+		op       Operator
+		expected string
+	}{
+		{"contains", Operator{Eval: eval}, "operator 'contains' is a built-in keyword"},
+		{"a.b", Operator{Eval: eval}, "invalid operator name 'a.b'"},
+		{"has space", Operator{Eval: eval}, "invalid operator name 'has space'"},
+		{"noEval", Operator{}, "operator 'noEval' has no Eval function"},
+		{"badArity", Operator{Arity: -2, Eval: eval}, "invalid arity -2 for operator 'badArity'"},
+		{"twice", Operator{Eval: eval}, "operator 'twice' is already registered"},
+	}
+
+	env := NewEnv()
+	if err := env.RegisterOperator("twice", Operator{Eval: eval}); err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	for _, tt := range tests {
+		err := env.RegisterOperator(tt.name, tt.op)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	input := `
+group and
+    price gt 100
+    group or
+        category in ["electronics", "furniture"]
+        stock between 50 and 100
+status eq "active"
+title not contains "deleted"
+`
+	context := map[string]interface{}{
+		"price":    120,
+		"category": "toys",
+		"stock":    75.0,
+		"status":   "inactive",
+		"title":    "Chair",
+	}
+
+	nodes, err := newTestParser(input).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	trace, err := Explain(nodes, context)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	if trace.Result || len(trace.Children) != 3 {
+		t.Fatalf("Unexpected root trace: %+v", trace)
+	}
+
+	group := trace.Children[0]
+	if group.Group == nil || !group.Result || len(group.Children) != 2 {
+		t.Fatalf("Unexpected group trace: %+v", group)
+	}
+
+	price := group.Children[0]
+	if price.Condition == nil || price.FieldValue != 120 || !slicesEqual(price.Value, parser.Value{100.0}) || !price.Result {
+		t.Errorf("Unexpected condition trace: %+v", price)
+	}
+
+	status := trace.Children[1]
+	if status.Result || status.Skipped || status.FieldValue != "inactive" {
+		t.Errorf("Unexpected condition trace: %+v", status)
+	}
+
+	if !trace.Children[2].Skipped {
+		t.Errorf("Expected the last condition to be skipped")
+	}
+
+	expected := `[false] rule
+  [true] group and (line 2)
+    [true] price gt 100 (price = 120)
+    [true] group or (line 4)
+      [false] category in ["electronics", "furniture"] (category = "toys")
+      [true] stock between 50 and 100 (stock = 75)
+  [false] status eq "active" (status = "inactive")
+  [skipped] title not contains "deleted"
+`
+	if trace.String() != expected {
+		t.Errorf("Expected rendering:\n%s\ngot:\n%s", expected, trace.String())
+	}
+}
+
+func TestExplainError(t *testing.T) {
+	nodes, err := newTestParser(`
+age gt 18
+age lt "twenty"
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	trace, err := Explain(nodes, map[string]interface{}{"age": 30})
+	if err == nil {
+		t.Fatalf("Expected an error but got none")
+	}
+
+	if len(trace.Children) != 2 || trace.Children[1].Err == nil {
+		t.Fatalf("Expected the failing condition to carry the error: %+v", trace)
+	}
+
+	expected := "[false] rule\n" +
+		"  [true] age gt 18 (age = 30)\n" +
+		"  [error] age lt \"twenty\" (line 3, column 1: invalid types for numeric comparison: int and string)\n"
+	if trace.String() != expected {
+		t.Errorf("Expected rendering:\n%s\ngot:\n%s", expected, trace.String())
+	}
+}
+
+func TestExplainContextValues(t *testing.T) {
+	nodes, err := newTestParser(`
+tags containsAny ["b"]
+attrs isEmpty
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	context := map[string]interface{}{
+		"tags":  []interface{}{"b", 1},
+		"attrs": map[string]interface{}{"size": "L", "count": 2},
+	}
+
+	trace, err := NewProgram(nodes, Options{}).Explain(context)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	// Strings in arrays and maps from the context keep their quotes
+	expected := `[false] rule
+  [true] tags containsAny ["b"] (tags = ["b", 1])
+  [false] attrs isEmpty (attrs = {"count": 2, "size": "L"})
+`
+	if trace.String() != expected {
+		t.Errorf("Expected rendering:\n%s\ngot:\n%s", expected, trace.String())
+	}
+}
+
+func TestExplainShortCircuit(t *testing.T) {
+	nodes, err := newTestParser(`
+group or
+    age gt 18
+    group and
+        name eq "x"
+        title contains "y"
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	trace, err := Explain(nodes, map[string]interface{}{"age": 20})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	expected := `[true] rule
+  [true] group or (line 2)
+    [true] age gt 18 (age = 20)
+    [skipped] group and (line 4)
+      [skipped] name eq "x"
+      [skipped] title contains "y"
+`
+	if trace.String() != expected {
+		t.Errorf("Expected rendering:\n%s\ngot:\n%s", expected, trace.String())
+	}
+}
+
+func TestExplainMissingField(t *testing.T) {
+	nodes, err := newTestParser(`
+coupon not exists
+discount eq nil
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	trace, err := Explain(nodes, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	expected := `[true] rule
+  [true] coupon not exists (coupon is missing)
+  [true] discount eq nil (discount is missing)
+`
+	if trace.String() != expected {
+		t.Errorf("Expected rendering:\n%s\ngot:\n%s", expected, trace.String())
+	}
+}
+
+func TestOrderByCost(t *testing.T) {
+	nodes, err := newTestParser(`
+group and
+    title matches "^a"
+    name icontains "B"
+    price gt 5
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	context := map[string]interface{}{"price": 1, "title": "abc", "name": "abc"}
+
+	// As written, the failing price check comes last
+	trace, err := NewProgram(nodes, Options{}).Explain(context)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+	if trace.Children[0].Children[0].Skipped {
+		t.Errorf("Expected the pattern to be checked first")
+	}
+
+	// By cost, the cheap price check runs first and decides the group
+	program := NewProgram(nodes, Options{Order: OrderByCost})
+	trace, err = program.Explain(context)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	group := trace.Children[0]
+	if !group.Children[0].Skipped || !group.Children[1].Skipped || group.Children[2].Skipped {
+		t.Errorf("Expected only the price check to run:\n%s", trace)
+	}
+
+	// Traces stay in source order
+	if group.Children[2].Condition.Field != "price" {
+		t.Errorf("Expected the trace to keep the source order:\n%s", trace)
+	}
+}
+
+func TestOrderHoldsErrors(t *testing.T) {
+	nodes, err := newTestParser(`
+group and
+    name icontains "zzz"
+    b gt 5
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	// By cost, the failing numeric check runs first, but as written the name
+	// check decides the group before it is reached
+	context := map[string]interface{}{"name": "abc", "b": "x"}
+	for _, order := range []Order{OrderAsWritten, OrderByCost} {
+		result, err := NewProgram(nodes, Options{Order: order}).Evaluate(context)
+		if err != nil || result {
+			t.Errorf("Expected false without an error for order %d, got %v, %v", order, result, err)
+		}
+	}
+
+	// Once the name check passes, the error is reported
+	context["name"] = "zzz"
+	_, err = NewProgram(nodes, Options{Order: OrderByCost}).Evaluate(context)
+	if err == nil {
+		t.Errorf("Expected an error but got none")
+	}
+}
+
+func TestOrderBySelectivity(t *testing.T) {
+	nodes, err := newTestParser(`
+group and
+    status eq "active"
+    country eq "TR"
+`).Parse()
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	// The first check always passes and the second one always fails, so the
+	// second one decides the group and should move to the front
+	context := map[string]interface{}{"status": "active", "country": "DE"}
+	program := NewProgram(nodes, Options{Order: OrderBySelectivity})
+
+	for i := 0; i < 3*replanInterval; i++ {
+		result, err := program.Evaluate(context)
+		if err != nil || result {
+			t.Fatalf("Expected false without an error, got %v, %v", result, err)
+		}
+	}
+
+	trace, err := program.Explain(context)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	group := trace.Children[0]
+	if !group.Children[0].Skipped || group.Children[1].Skipped {
+		t.Errorf("Expected the selective check to run first:\n%s", trace)
+	}
+}
+
+func slicesEqual(a, b parser.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,148 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// Trace records how a rule, group or condition was evaluated. The traces of a
+// rule form a tree with the same shape as the parsed Group and Condition
+// nodes, under a root trace that stands for the whole rule.
+type Trace struct {
+	Condition *parser.Condition // the traced condition, nil for groups and the root
+	Group     *parser.Group     // the traced group, nil for conditions and the root
+
+	FieldValue interface{}  // value the condition's field resolved to
+	Missing    bool         // true if the condition's field is not in the context
+	Value      parser.Value // value the field was compared with
+	Result     bool
+	Skipped    bool  // true if the node was not evaluated because the result was already decided
+	Err        error // error that stopped the evaluation of this node, if any
+
+	Children []*Trace
+}
+
+// Explain evaluates the nodes like EvaluateNodes and returns a trace of every
+// step. When the evaluation fails, the trace up to the failing condition is
+// returned along with the error.
+func Explain(nodes []interface{}, context map[string]interface{}) (*Trace, error) {
+	return NewProgram(nodes, Options{}).Explain(context)
+}
+
+// skippedChildren creates the traces of the given child nodes, all marked as
+// skipped until they are evaluated. It returns nil when tracing is off.
+func (t *Trace) skippedChildren(nodes []interface{}) []*Trace {
+	if t == nil {
+		return nil
+	}
+
+	children := make([]*Trace, len(nodes))
+	for i, node := range nodes {
+		child := &Trace{Skipped: true}
+		switch node := node.(type) {
+		case *parser.Condition:
+			child.Condition = node
+		case *parser.Group:
+			child.Group = node
+		}
+
+		children[i] = child
+	}
+
+	t.Children = append(t.Children, children...)
+	return children
+}
+
+// expandSkipped fills in the traces below skipped groups, so that the trace
+// keeps the shape of the rule.
+func (t *Trace) expandSkipped() {
+	if t == nil {
+		return
+	}
+
+	for _, child := range t.Children {
+		if child.Skipped && child.Group != nil && child.Children == nil {
+			child.skippedChildren(child.Group.Children)
+			child.expandSkipped()
+		}
+	}
+}
+
+func (t *Trace) setMissing() {
+	if t != nil {
+		t.Missing = true
+	}
+}
+
+func (t *Trace) setValues(fieldValue interface{}, value parser.Value) {
+	if t == nil {
+		return
+	}
+
+	if set, ok := fieldValue.(matchSet); ok {
+		fieldValue = set.values()
+	}
+
+	t.FieldValue = fieldValue
+	t.Value = value
+}
+
+// record stores the outcome of the traced node and returns it unchanged.
+func (t *Trace) record(result bool, err error) (bool, error) {
+	if t != nil {
+		t.Result = result
+		t.Err = err
+	}
+
+	return result, err
+}
+
+// String renders the trace as an indented tree, one node per line:
+//
+//	[false] rule
+//	  [true] group and (line 2)
+//	    [true] price gt 100 (price = 120)
+//	  [false] status eq "active" (status = "inactive")
+//	  [skipped] title not contains "deleted"
+func (t *Trace) String() string {
+	var builder strings.Builder
+	t.write(&builder, 0)
+
+	return builder.String()
+}
+
+func (t *Trace) write(builder *strings.Builder, depth int) {
+	builder.WriteString(strings.Repeat("  ", depth))
+
+	switch {
+	case t.Skipped:
+		builder.WriteString("[skipped] ")
+	case t.Err != nil && t.Condition != nil:
+		builder.WriteString("[error] ")
+	default:
+		fmt.Fprintf(builder, "[%t] ", t.Result)
+	}
+
+	switch {
+	case t.Condition != nil:
+		builder.WriteString(t.Condition.String())
+		if t.Err != nil {
+			fmt.Fprintf(builder, " (%v)", t.Err)
+		} else if t.Missing {
+			fmt.Fprintf(builder, " (%s is missing)", t.Condition.Field)
+		} else if !t.Skipped {
+			fmt.Fprintf(builder, " (%s = %s)", t.Condition.Field, parser.FormatValue(t.FieldValue))
+		}
+	case t.Group != nil:
+		fmt.Fprintf(builder, "%s (line %d)", t.Group, t.Group.Pos.Line)
+	default:
+		builder.WriteString("rule")
+	}
+	builder.WriteString("\n")
+
+	for _, child := range t.Children {
+		child.write(builder, depth+1)
+	}
+}
@@ -0,0 +1,50 @@
+package evaluator
+
+import (
+	"strings"
+	"unicode"
+)
+
+// foldCase maps every rune of s to a canonical member of its Unicode simple
+// case folding orbit, so that strings which only differ in case become
+// identical. Unlike strings.ToLower this also equates runes such as the
+// Kelvin sign and 'k', or 'ſ' and 's'.
+func foldCase(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+func foldRune(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+
+	return folded
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(foldCase(s), foldCase(substr))
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return strings.HasPrefix(foldCase(s), foldCase(prefix))
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return strings.HasSuffix(foldCase(s), foldCase(suffix))
+}
+
+// valuesEqualFold is like valuesEqual but compares strings without regard to
+// case.
+func valuesEqualFold(a, b interface{}) (bool, error) {
+	aStr, aIsString := a.(string)
+	bStr, bIsString := b.(string)
+
+	if aIsString && bIsString {
+		return foldCase(aStr) == foldCase(bStr), nil
+	}
+
+	return valuesEqual(a, b)
+}
@@ -0,0 +1,416 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alicavdar/logix/lexer"
+	"github.com/alicavdar/logix/parser"
+)
+
+// Function is a custom function implemented in Go that rules can call, like
+// riskScore(user_id).
+type Function struct {
+	// Params are the types of the parameters. Arguments are checked against
+	// them when the rule is compiled, as far as their types are known then,
+	// and again before every call.
+	Params []Type
+	// Variadic allows the last parameter to be repeated any number of times,
+	// including none, like in Go.
+	Variadic bool
+	// Result is the type of the value Call returns.
+	Result Type
+	// Call computes the result. ctx is the context passed to
+	// Program.EvaluateWithContext, or context.Background().
+	Call func(ctx context.Context, args []interface{}) (interface{}, error)
+}
+
+// function is a function that can be called in a rule, like len(items).
+type function struct {
+	params  []Type // the last type is used for any further arguments
+	minArgs int
+	maxArgs int // -1 for any number of arguments
+	result  Type
+	call    func(e *evaluation, args []interface{}) (interface{}, error)
+	// validate, if set, checks arguments that are literals when the rule is
+	// compiled, like the version string of version("2.10.0").
+	validate func(args []interface{}) error
+}
+
+// functions is the standard library available to every rule.
+var functions = map[string]function{
+	"len":     {params: []Type{TypeAny}, minArgs: 1, maxArgs: 1, result: TypeNumber, call: callLen},
+	"lower":   {params: []Type{TypeString}, minArgs: 1, maxArgs: 1, result: TypeString, call: stringFunction(strings.ToLower)},
+	"upper":   {params: []Type{TypeString}, minArgs: 1, maxArgs: 1, result: TypeString, call: stringFunction(strings.ToUpper)},
+	"trim":    {params: []Type{TypeString}, minArgs: 1, maxArgs: 1, result: TypeString, call: stringFunction(strings.TrimSpace)},
+	"abs":     {params: []Type{TypeNumber}, minArgs: 1, maxArgs: 1, result: TypeNumber, call: numberFunction(math.Abs)},
+	"floor":   {params: []Type{TypeNumber}, minArgs: 1, maxArgs: 1, result: TypeNumber, call: numberFunction(math.Floor)},
+	"ceil":    {params: []Type{TypeNumber}, minArgs: 1, maxArgs: 1, result: TypeNumber, call: numberFunction(math.Ceil)},
+	"round":   {params: []Type{TypeNumber, TypeNumber}, minArgs: 1, maxArgs: 2, result: TypeNumber, call: callRound},
+	"min":     {params: []Type{TypeAny}, minArgs: 1, maxArgs: -1, result: TypeNumber, call: extremeFunction(func(a, b float64) bool { return a < b })},
+	"max":     {params: []Type{TypeAny}, minArgs: 1, maxArgs: -1, result: TypeNumber, call: extremeFunction(func(a, b float64) bool { return a > b })},
+	"substr":  {params: []Type{TypeString, TypeNumber, TypeNumber}, minArgs: 2, maxArgs: 3, result: TypeString, call: callSubstr},
+	"now":     {minArgs: 0, maxArgs: 0, result: TypeTime, call: callNow},
+	"version": {params: []Type{TypeString}, minArgs: 1, maxArgs: 1, result: TypeVersion, call: callVersion, validate: validateVersion},
+	"ip":      {params: []Type{TypeString}, minArgs: 1, maxArgs: 1, result: TypeIP, call: callIP, validate: validateIP},
+}
+
+// RegisterFunction adds a custom function to the Env. The name must be a valid
+// identifier that is not a Logix keyword, a built-in function or a function
+// that is already registered.
+func (env *Env) RegisterFunction(name string, fn Function) error {
+	if lexer.IsKeyword(name) {
+		return fmt.Errorf("function '%s' is a built-in keyword", name)
+	}
+
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid function name '%s'", name)
+	}
+
+	if _, ok := functions[name]; ok {
+		return fmt.Errorf("function '%s' is a built-in function", name)
+	}
+
+	if _, ok := env.functions[name]; ok {
+		return fmt.Errorf("function '%s' is already registered", name)
+	}
+
+	if fn.Call == nil {
+		return fmt.Errorf("function '%s' has no Call function", name)
+	}
+
+	if fn.Variadic && len(fn.Params) == 0 {
+		return fmt.Errorf("variadic function '%s' needs at least one parameter", name)
+	}
+
+	registered := function{
+		params:  fn.Params,
+		minArgs: len(fn.Params),
+		maxArgs: len(fn.Params),
+		result:  fn.Result,
+		call: func(e *evaluation, args []interface{}) (interface{}, error) {
+			return fn.Call(e.ctx, args)
+		},
+	}
+	if fn.Variadic {
+		registered.minArgs--
+		registered.maxArgs = -1
+	}
+
+	env.functions[name] = registered
+	return nil
+}
+
+// function looks up a built-in or registered function.
+func (env *Env) function(name string) (function, bool) {
+	if fn, ok := functions[name]; ok {
+		return fn, true
+	}
+
+	fn, ok := env.functions[name]
+	return fn, ok
+}
+
+// param returns the type of the argument at the given index.
+func (fn function) param(index int) Type {
+	if len(fn.params) == 0 {
+		return TypeAny
+	}
+
+	return fn.params[min(index, len(fn.params)-1)]
+}
+
+// invoke checks the arguments against the parameter types and calls the
+// function.
+func (fn function) invoke(e *evaluation, args []interface{}) (interface{}, error) {
+	for i, arg := range args {
+		converted, err := fn.param(i).convert(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+
+		args[i] = converted
+	}
+
+	if err := e.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := fn.call(e, args)
+	if err != nil {
+		return nil, err
+	}
+
+	converted, err := fn.result.convert(result)
+	if err != nil {
+		return nil, fmt.Errorf("result: %w", err)
+	}
+
+	return converted, nil
+}
+
+// checkArity reports an error when a function is called with the wrong number
+// of arguments.
+func (fn function) checkArity(name string, count int) error {
+	if count >= fn.minArgs && (fn.maxArgs < 0 || count <= fn.maxArgs) {
+		return nil
+	}
+
+	var expected string
+	switch {
+	case fn.maxArgs < 0:
+		expected = fmt.Sprintf("at least %d", fn.minArgs)
+	case fn.minArgs == fn.maxArgs:
+		expected = strconv.Itoa(fn.minArgs)
+	default:
+		expected = fmt.Sprintf("%d to %d", fn.minArgs, fn.maxArgs)
+	}
+
+	noun := "arguments"
+	if expected == "1" || expected == "at least 1" {
+		noun = "argument"
+	}
+
+	return fmt.Errorf("function '%s' expects %s %s, got %d", name, expected, noun, count)
+}
+
+// evaluateExpr computes the value of an expression. A field that is missing
+// in the context is returned as a *missingFieldError, unless it is only an
+// operand of a larger expression and missing fields are treated as nil.
+func (e *evaluation) evaluateExpr(expr parser.Expr) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *parser.FieldRef:
+		value, err := resolveFieldValue(expr.Path, e.context)
+		if set, ok := value.(matchSet); ok {
+			value = set.values()
+		}
+
+		return value, err
+	case *parser.Literal:
+		return expr.Value, nil
+	case *parser.CallExpr:
+		fn, ok := e.program.options.env().function(expr.Func)
+		if !ok {
+			return nil, fmt.Errorf("unknown function '%s'", expr.Func)
+		}
+
+		if err := fn.checkArity(expr.Func, len(expr.Args)); err != nil {
+			return nil, err
+		}
+
+		args := make([]interface{}, len(expr.Args))
+		for i, arg := range expr.Args {
+			value, err := e.evaluateOperand(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			args[i] = value
+		}
+
+		value, err := fn.invoke(e, args)
+		if err != nil {
+			return nil, fmt.Errorf("function '%s': %w", expr.Func, err)
+		}
+
+		return value, nil
+	case *parser.UnaryExpr:
+		operand, err := e.evaluateOperand(expr.Operand)
+		if err != nil {
+			return nil, err
+		}
+
+		return negate(expr, operand)
+	case *parser.BinaryExpr:
+		left, err := e.evaluateOperand(expr.Left)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := e.evaluateOperand(expr.Right)
+		if err != nil {
+			return nil, err
+		}
+
+		return calculate(expr, left, right)
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", expr)
+	}
+}
+
+// evaluateOperand computes an operand of a larger expression, such as a
+// function argument. Under MissingFieldNil a missing field is nil, unless it
+// is an array index out of range.
+func (e *evaluation) evaluateOperand(expr parser.Expr) (interface{}, error) {
+	value, err := e.evaluateExpr(expr)
+
+	var missing *missingFieldError
+	if errors.As(err, &missing) && e.program.options.MissingField == MissingFieldNil && !missing.outOfRange {
+		return nil, nil
+	}
+
+	return value, err
+}
+
+// callLen returns the number of runes in a string, or the number of elements
+// in an array or map. nil has a length of 0.
+func callLen(e *evaluation, args []interface{}) (interface{}, error) {
+	length, ok := lengthOf(args[0])
+	if !ok {
+		return nil, fmt.Errorf("expected a string, an array or a map, got %T", args[0])
+	}
+
+	return float64(length), nil
+}
+
+func lengthOf(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case nil:
+		return 0, true
+	case string:
+		return utf8.RuneCountInString(v), true
+	case []interface{}:
+		return len(v), true
+	case map[string]interface{}:
+		return len(v), true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len(), true
+	default:
+		return 0, false
+	}
+}
+
+// stringFunction turns a string transformation into a function of one string.
+func stringFunction(transform func(string) string) func(e *evaluation, args []interface{}) (interface{}, error) {
+	return func(e *evaluation, args []interface{}) (interface{}, error) {
+		return transform(args[0].(string)), nil
+	}
+}
+
+// numberFunction turns a float64 function into a function of one number.
+func numberFunction(transform func(float64) float64) func(e *evaluation, args []interface{}) (interface{}, error) {
+	return func(e *evaluation, args []interface{}) (interface{}, error) {
+		return transform(args[0].(float64)), nil
+	}
+}
+
+// extremeFunction returns the number for which better holds against every
+// other argument. A single array argument stands for its elements, so
+// max(items[*].price) is the highest price.
+func extremeFunction(better func(a, b float64) bool) func(e *evaluation, args []interface{}) (interface{}, error) {
+	return func(e *evaluation, args []interface{}) (interface{}, error) {
+		if len(args) == 1 {
+			if list, ok := toList(args[0]); ok {
+				if len(list) == 0 {
+					return nil, fmt.Errorf("expected at least one number, got an empty array")
+				}
+
+				args = list
+			}
+		}
+
+		result, err := numberArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, arg := range args[1:] {
+			number, err := numberArg(arg)
+			if err != nil {
+				return nil, err
+			}
+
+			if better(number, result) {
+				result = number
+			}
+		}
+
+		return result, nil
+	}
+}
+
+// callRound rounds a number to the given number of decimal places, 0 by
+// default. Halves are rounded away from zero.
+func callRound(e *evaluation, args []interface{}) (interface{}, error) {
+	number := args[0].(float64)
+
+	places := 0
+	if len(args) > 1 {
+		var err error
+		places, err = intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	scale := math.Pow(10, float64(places))
+	return math.Round(number*scale) / scale, nil
+}
+
+// callSubstr returns the part of a string that starts at the given rune index
+// and has at most the given number of runes, or runs to the end of the string.
+func callSubstr(e *evaluation, args []interface{}) (interface{}, error) {
+	runes := []rune(args[0].(string))
+
+	start, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("start must not be negative, got %d", start)
+	}
+	start = min(start, len(runes))
+
+	end := len(runes)
+	if len(args) > 2 {
+		length, err := intArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 {
+			return nil, fmt.Errorf("length must not be negative, got %d", length)
+		}
+
+		end = min(start+length, len(runes))
+	}
+
+	return string(runes[start:end]), nil
+}
+
+func callNow(e *evaluation, args []interface{}) (interface{}, error) {
+	return e.now(), nil
+}
+
+func numberArg(value interface{}) (float64, error) {
+	number, ok, err := toFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+
+	return number, nil
+}
+
+// intArg returns a number that has already been converted to float64 as an
+// int, if it is a whole number.
+func intArg(value interface{}) (int, error) {
+	number := value.(float64)
+	if number != math.Trunc(number) {
+		return 0, fmt.Errorf("expected a whole number, got %v", number)
+	}
+
+	return int(number), nil
+}
@@ -0,0 +1,129 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// earthRadius is the mean radius of the Earth in kilometres.
+const earthRadius = 6371.0088
+
+// toPoint converts a location in the context to its latitude and longitude. A
+// location is either a [latitude, longitude] pair or a map with lat and lon
+// (or lng, or latitude and longitude) keys. The second result is false for
+// anything else.
+func toPoint(value interface{}) (float64, float64, bool, error) {
+	var lat, lon interface{}
+	if point, ok := value.(map[string]interface{}); ok {
+		lat = firstKey(point, "lat", "latitude")
+		lon = firstKey(point, "lon", "lng", "longitude")
+	} else if pair, ok := toList(value); ok && len(pair) == 2 {
+		lat, lon = pair[0], pair[1]
+	}
+
+	latFloat, ok, err := toFloat64(lat)
+	if err != nil || !ok {
+		return 0, 0, false, err
+	}
+
+	lonFloat, ok, err := toFloat64(lon)
+	if err != nil || !ok {
+		return 0, 0, false, err
+	}
+
+	return latFloat, lonFloat, true, nil
+}
+
+func firstKey(m map[string]interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		if value, ok := m[key]; ok {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// haversine returns the great-circle distance between two points in
+// kilometres.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// evaluateWithinRadius reports whether the field is a location at most the
+// radius away from the centre. values holds the latitude and longitude of the
+// centre and the radius in kilometres.
+func evaluateWithinRadius(cond *parser.Condition, fieldValue interface{}, values parser.Value) (bool, error) {
+	lat, lon, ok, err := toPoint(fieldValue)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, fmt.Errorf("the field value is not a location for 'withinRadius' operator")
+	}
+
+	var circle [3]float64
+	for i, value := range values {
+		number, ok, err := toFloat64(value)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return false, fmt.Errorf("invalid types for 'withinRadius' operator")
+		}
+
+		circle[i] = number
+	}
+
+	result := haversine(lat, lon, circle[0], circle[1]) <= circle[2]
+	return applyNegation(result, cond.Negate), nil
+}
+
+// evaluateInsidePolygon reports whether the field is a location inside the
+// polygon the parser compiled. Points outside the bounding box are rejected
+// right away, the others are checked by casting a ray along their latitude and
+// counting the edges it crosses. Coordinates are treated as planar, which is
+// accurate enough for areas of the size of a city.
+func evaluateInsidePolygon(cond *parser.Condition, fieldValue interface{}) (bool, error) {
+	lat, lon, ok, err := toPoint(fieldValue)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, fmt.Errorf("the field value is not a location for 'insidePolygon' operator")
+	}
+
+	polygon, ok := cond.Compiled.(*parser.Polygon)
+	if !ok {
+		return false, fmt.Errorf("the polygon for 'insidePolygon' operator was not compiled")
+	}
+
+	if lat < polygon.MinLat || lat > polygon.MaxLat || lon < polygon.MinLon || lon > polygon.MaxLon {
+		return applyNegation(false, cond.Negate), nil
+	}
+
+	inside := false
+	vertices := polygon.Vertices
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		latI, lonI := vertices[i][0], vertices[i][1]
+		latJ, lonJ := vertices[j][0], vertices[j][1]
+
+		if (latI > lat) != (latJ > lat) && lon < (lonJ-lonI)*(lat-latI)/(latJ-latI)+lonI {
+			inside = !inside
+		}
+	}
+
+	return applyNegation(inside, cond.Negate), nil
+}
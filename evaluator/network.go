@@ -0,0 +1,118 @@
+package evaluator
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// toAddr converts a netip.Addr, a net.IP, or a string holding an IPv4 or IPv6
+// address to an address. IPv4 addresses mapped into IPv6, like
+// ::ffff:10.0.0.1, are returned as plain IPv4 addresses so that both forms
+// compare equal. The second result is false for anything else.
+func toAddr(value interface{}) (netip.Addr, bool) {
+	var addr netip.Addr
+	switch v := value.(type) {
+	case netip.Addr:
+		addr = v
+	case net.IP:
+		addr, _ = netip.AddrFromSlice(v)
+	case string:
+		addr, _ = netip.ParseAddr(v)
+	}
+
+	return addr.Unmap(), addr.IsValid()
+}
+
+// toPrefix converts a netip.Prefix, a *net.IPNet, or a string in CIDR
+// notation to a prefix.
+func toPrefix(value interface{}) (netip.Prefix, error) {
+	switch v := value.(type) {
+	case netip.Prefix:
+		return v.Masked(), nil
+	case *net.IPNet:
+		if prefix, err := netip.ParsePrefix(v.String()); err == nil {
+			return prefix.Masked(), nil
+		}
+	case string:
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		return prefix.Masked(), nil
+	}
+
+	return netip.Prefix{}, fmt.Errorf("expected a network prefix, got %T", value)
+}
+
+// evaluateInCidr reports whether the field is an address in one of the
+// networks. The prefixes were parsed by the parser when they are all literals.
+func evaluateInCidr(cond *parser.Condition, fieldValue interface{}, values parser.Value) (bool, error) {
+	addr, ok := toAddr(fieldValue)
+	if !ok {
+		return false, fmt.Errorf("the field value is not an IP address for 'inCidr' operator")
+	}
+
+	prefixes, compiled := cond.Compiled.([]netip.Prefix)
+	if !compiled {
+		prefixes = make([]netip.Prefix, len(values))
+		for i, value := range values {
+			prefix, err := toPrefix(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid prefix for 'inCidr': %v", err)
+			}
+
+			prefixes[i] = prefix
+		}
+	}
+
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return applyNegation(true, cond.Negate), nil
+		}
+	}
+
+	return applyNegation(false, cond.Negate), nil
+}
+
+// classifyAddr applies isPrivate or isLoopback to the field.
+func classifyAddr(cond *parser.Condition, fieldValue interface{}, class func(netip.Addr) bool) (bool, error) {
+	addr, ok := toAddr(fieldValue)
+	if !ok {
+		return false, fmt.Errorf("the field value is not an IP address for '%s' operator", cond.Operator)
+	}
+
+	return applyNegation(class(addr), cond.Negate), nil
+}
+
+// addrsEqual compares two values as IP addresses if one of them is a
+// netip.Addr. The second result is false when neither is.
+func addrsEqual(a, b interface{}) (bool, bool) {
+	_, aIsAddr := a.(netip.Addr)
+	_, bIsAddr := b.(netip.Addr)
+	if !aIsAddr && !bIsAddr {
+		return false, false
+	}
+
+	aAddr, ok := toAddr(a)
+	bAddr, ok2 := toAddr(b)
+
+	return ok && ok2 && aAddr == bAddr, true
+}
+
+func callIP(e *evaluation, args []interface{}) (interface{}, error) {
+	addr, ok := toAddr(args[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid IP address '%s'", args[0])
+	}
+
+	return addr, nil
+}
+
+func validateIP(args []interface{}) error {
+	_, err := callIP(nil, args)
+	return err
+}
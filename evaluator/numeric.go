@@ -0,0 +1,212 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// toFloat64 widens any Go numeric type to float64. The second result is false
+// when the value is not a number. An error is returned for numbers that cannot
+// be represented as a float64 without losing their meaning, such as a
+// malformed json.Number or a *big.Int outside the float64 range. Integers
+// beyond 2^53 are rounded, so comparisons check exactNumbers first.
+func toFloat64(value interface{}) (float64, bool, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, true, nil
+	case float32:
+		return float64(v), true, nil
+	case int:
+		return float64(v), true, nil
+	case int8:
+		return float64(v), true, nil
+	case int16:
+		return float64(v), true, nil
+	case int32:
+		return float64(v), true, nil
+	case int64:
+		return float64(v), true, nil
+	case uint:
+		return float64(v), true, nil
+	case uint8:
+		return float64(v), true, nil
+	case uint16:
+		return float64(v), true, nil
+	case uint32:
+		return float64(v), true, nil
+	case uint64:
+		return float64(v), true, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, true, fmt.Errorf("invalid JSON number '%s'", v)
+		}
+
+		return f, true, nil
+	case *big.Int:
+		if v == nil {
+			return 0, false, nil
+		}
+
+		f, _ := new(big.Float).SetInt(v).Float64()
+		if math.IsInf(f, 0) {
+			return 0, true, fmt.Errorf("number %s is out of range", v)
+		}
+
+		return f, true, nil
+	case *big.Float:
+		if v == nil {
+			return 0, false, nil
+		}
+
+		f, _ := v.Float64()
+		if math.IsInf(f, 0) && !v.IsInf() {
+			return 0, true, fmt.Errorf("number %s is out of range", v)
+		}
+
+		return f, true, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// maxExactInt is the largest integer up to which every integer is exactly a
+// float64.
+const maxExactInt = 1 << 53
+
+// exactNumbers returns two numbers as big.Floats holding exactly their values
+// when widening one of them to float64 would round it, like an int64 above
+// 2^53 or a *big.Int with more digits than a float64 holds. Distinct numbers
+// could then compare equal as float64s. It returns nil when both numbers are
+// exactly float64s, which are then compared as they are.
+func exactNumbers(a, b interface{}) (*big.Float, *big.Float) {
+	x, xRounds := exactNumber(a)
+	y, yRounds := exactNumber(b)
+	if x == nil || y == nil || !xRounds && !yRounds {
+		return nil, nil
+	}
+
+	return x, y
+}
+
+// exactNumber converts a number to a big.Float holding exactly its value. The
+// second result reports whether toFloat64 rounds the number. It returns nil for
+// values that are not numbers and for NaN.
+func exactNumber(value interface{}) (*big.Float, bool) {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) {
+			return nil, false
+		}
+
+		return new(big.Float).SetFloat64(v), false
+	case float32:
+		return exactNumber(float64(v))
+	case int:
+		return exactInt(int64(v))
+	case int8:
+		return exactInt(int64(v))
+	case int16:
+		return exactInt(int64(v))
+	case int32:
+		return exactInt(int64(v))
+	case int64:
+		return exactInt(v)
+	case uint:
+		return exactUint(uint64(v))
+	case uint8:
+		return exactUint(uint64(v))
+	case uint16:
+		return exactUint(uint64(v))
+	case uint32:
+		return exactUint(uint64(v))
+	case uint64:
+		return exactUint(v)
+	case json.Number:
+		if i, ok := new(big.Int).SetString(string(v), 10); ok {
+			return exactNumber(i)
+		}
+
+		// Decimals like 0.1 are rounded on both sides alike
+		f, err := v.Float64()
+		if err != nil {
+			return nil, false
+		}
+
+		return exactNumber(f)
+	case *big.Int:
+		if v == nil {
+			return nil, false
+		}
+
+		return new(big.Float).SetInt(v), v.CmpAbs(big.NewInt(maxExactInt)) > 0
+	case *big.Float:
+		if v == nil {
+			return nil, false
+		}
+
+		_, accuracy := v.Float64()
+		return v, accuracy != big.Exact
+	default:
+		return nil, false
+	}
+}
+
+func exactInt(v int64) (*big.Float, bool) {
+	return new(big.Float).SetInt64(v), v > maxExactInt || v < -maxExactInt
+}
+
+func exactUint(v uint64) (*big.Float, bool) {
+	return new(big.Float).SetUint64(v), v > maxExactInt
+}
+
+// valuesEqual compares two values the way eq, neq and in do: numbers are
+// equal when their exact values are equal, everything else must match
+// deeply. Times are equal when they refer to the same instant, whatever their
+// zone, a duration equals a number of seconds of the same length, versions
+// are equal when they have the same precedence, and an IPv4 address equals
+// its IPv4-mapped IPv6 form.
+func valuesEqual(a, b interface{}) (bool, error) {
+	if equal, ok := timesEqual(a, b); ok {
+		return equal, nil
+	}
+
+	if equal, ok := durationsEqual(a, b); ok {
+		return equal, nil
+	}
+
+	if equal, ok, err := versionsEqual(a, b); ok {
+		return equal, err
+	}
+
+	if equal, ok := addrsEqual(a, b); ok {
+		return equal, nil
+	}
+
+	aFloat, aIsNumber, err := toFloat64(a)
+	if err != nil {
+		return false, err
+	}
+
+	bFloat, bIsNumber, err := toFloat64(b)
+	if err != nil {
+		return false, err
+	}
+
+	if aIsNumber && bIsNumber {
+		if x, y := exactNumbers(a, b); x != nil {
+			return x.Cmp(y) == 0, nil
+		}
+
+		return aFloat == bFloat, nil
+	}
+
+	if aIsNumber || bIsNumber {
+		return false, nil
+	}
+
+	return reflect.DeepEqual(a, b), nil
+}
@@ -0,0 +1,205 @@
+package evaluator
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// replanInterval is how many evaluations of a group pass between two updates
+// of its order under OrderBySelectivity.
+const replanInterval = 128
+
+// groupPlan decides the order in which the children of one group are
+// evaluated.
+type groupPlan struct {
+	order []int     // static order of the children, as indices into the children
+	costs []float64 // estimated cost of each child
+
+	// Only used with OrderBySelectivity
+	stats    []childStats
+	adaptive atomic.Pointer[[]int] // order derived from stats, nil until the first update
+	runs     atomic.Int64
+}
+
+// childStats counts how often a child was evaluated and how often it held.
+type childStats struct {
+	evaluations atomic.Int64
+	passes      atomic.Int64
+}
+
+func (p *Program) planGroup(children []interface{}) *groupPlan {
+	plan := &groupPlan{
+		order: make([]int, len(children)),
+		costs: make([]float64, len(children)),
+	}
+
+	for i, child := range children {
+		plan.order[i] = i
+		plan.costs[i] = estimateCost(child)
+
+		if group, ok := child.(*parser.Group); ok {
+			p.plans[group] = p.planGroup(group.Children)
+		}
+	}
+
+	if p.options.Order != OrderAsWritten {
+		sort.SliceStable(plan.order, func(a, b int) bool {
+			return plan.costs[plan.order[a]] < plan.costs[plan.order[b]]
+		})
+	}
+
+	if p.options.Order == OrderBySelectivity {
+		plan.stats = make([]childStats, len(children))
+	}
+
+	return plan
+}
+
+// evaluationOrder returns the order in which to evaluate the children of a
+// group with the given logical operator.
+func (plan *groupPlan) evaluationOrder(logicalOp string) []int {
+	if plan.stats == nil {
+		return plan.order
+	}
+
+	if plan.runs.Add(1)%replanInterval == 0 {
+		plan.replan(logicalOp)
+	}
+
+	if adaptive := plan.adaptive.Load(); adaptive != nil {
+		return *adaptive
+	}
+
+	return plan.order
+}
+
+// observe records the result of a child when adaptive ordering is on.
+func (plan *groupPlan) observe(child int, result bool) {
+	if plan.stats == nil {
+		return
+	}
+
+	plan.stats[child].evaluations.Add(1)
+	if result {
+		plan.stats[child].passes.Add(1)
+	}
+}
+
+// replan orders the children by their cost divided by the observed chance
+// that they decide the group: failing for "and", passing for "or".
+func (plan *groupPlan) replan(logicalOp string) {
+	scores := make([]float64, len(plan.stats))
+	for i := range plan.stats {
+		evaluations := float64(plan.stats[i].evaluations.Load())
+		passes := float64(plan.stats[i].passes.Load())
+
+		decisive := evaluations - passes
+		if logicalOp == "or" {
+			decisive = passes
+		}
+
+		// Add-one smoothing keeps children that were rarely evaluated in the game
+		chance := (decisive + 1) / (evaluations + 2)
+		scores[i] = plan.costs[i] / chance
+	}
+
+	order := make([]int, len(plan.order))
+	copy(order, plan.order)
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] < scores[order[b]]
+	})
+
+	plan.adaptive.Store(&order)
+}
+
+// estimateCost gives a rough, relative cost of evaluating a node. Groups cost
+// as much as all of their children.
+func estimateCost(node interface{}) float64 {
+	switch node := node.(type) {
+	case *parser.Condition:
+		cost := pathCost(node.Field)
+		if node.Left != nil {
+			cost = exprCost(node.Left)
+		}
+
+		for _, value := range node.Value {
+			if expr, ok := value.(parser.Expr); ok {
+				cost += exprCost(expr)
+			}
+		}
+
+		switch node.Operator {
+		case "eq", "neq", "gt", "gte", "lt", "lte", "between", "exists", "isEmpty":
+			cost += 1
+		case "contains", "startsWith", "endsWith", "isPrivate", "isLoopback":
+			cost += 2
+		case "inCidr":
+			cost += 2 + float64(len(node.Value))/2
+		case "withinRadius":
+			cost += 4
+		case "insidePolygon":
+			// Most points are decided by the bounding box
+			cost += 2 + float64(len(node.Value))/8
+		case "ieq", "icontains", "istartsWith", "iendsWith":
+			cost += 4
+		case "in":
+			cost += 1 + float64(len(node.Value))/4
+		case "iin":
+			cost += 4 + float64(len(node.Value))
+		case "containsAny", "containsAll", "subsetOf", "intersects":
+			// Every element of the field is compared with every value
+			cost += 4 + float64(len(node.Value))
+		case "matches":
+			cost += 8
+		default:
+			cost += 2
+		}
+
+		// A wildcard repeats the work for every element of an array
+		if wildcards := strings.Count(node.Field, "[*]"); wildcards > 0 {
+			cost *= float64(4 * wildcards)
+		}
+
+		return cost
+	case *parser.Group:
+		var cost float64
+		for _, child := range node.Children {
+			cost += estimateCost(child)
+		}
+
+		return cost
+	default:
+		return 1
+	}
+}
+
+// pathCost estimates the cost of resolving a field path: every segment is a
+// map lookup or an index.
+func pathCost(path string) float64 {
+	return float64(strings.Count(path, ".") + strings.Count(path, "[") + 1)
+}
+
+// exprCost estimates the cost of computing an expression: the lookups of the
+// fields it uses plus one for every operation.
+func exprCost(expr parser.Expr) float64 {
+	switch expr := expr.(type) {
+	case *parser.FieldRef:
+		return pathCost(expr.Path)
+	case *parser.CallExpr:
+		cost := 1.0
+		for _, arg := range expr.Args {
+			cost += exprCost(arg)
+		}
+
+		return cost
+	case *parser.UnaryExpr:
+		return 1 + exprCost(expr.Operand)
+	case *parser.BinaryExpr:
+		return 1 + exprCost(expr.Left) + exprCost(expr.Right)
+	default:
+		return 0
+	}
+}
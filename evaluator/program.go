@@ -0,0 +1,134 @@
+package evaluator
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// Order controls the order in which the children of a group, and the
+// top-level nodes of a rule, are evaluated. Groups always stop as soon as
+// their result is decided, so running cheap and selective checks first saves
+// work on hot rules. An error is only reported when evaluating as written would
+// have reached the failing child, so reordering never turns a result into an
+// error. It can still hide an error that evaluating as written reports, when a
+// child that decides the group runs before the failing one.
+type Order int
+
+const (
+	// OrderAsWritten evaluates children in source order.
+	OrderAsWritten Order = iota
+	// OrderByCost evaluates children with the lowest estimated cost first.
+	OrderByCost
+	// OrderBySelectivity starts like OrderByCost and then adapts to the
+	// results seen so far, preferring cheap children that most often decide
+	// their group.
+	OrderBySelectivity
+)
+
+// MissingFieldPolicy decides what happens when a condition refers to a field
+// that is not in the context: a map key that does not exist, an array index
+// out of range, or a path below a nil value. The exists operator is not
+// affected by the policy.
+type MissingFieldPolicy int
+
+const (
+	// MissingFieldNil treats a missing field as nil, so `discount eq nil`
+	// holds when there is no discount. An array index out of range is still
+	// an error, as it was before there were policies.
+	MissingFieldNil MissingFieldPolicy = iota
+	// MissingFieldError makes the evaluation fail.
+	MissingFieldError
+	// MissingFieldFalse makes the condition false, even when it is negated.
+	MissingFieldFalse
+)
+
+// Options configures how a Program is evaluated.
+type Options struct {
+	Order        Order
+	MissingField MissingFieldPolicy
+	Env          *Env // custom operators and functions, nil for the built-in ones only
+	// Clock returns the current time for now. It is called at most once per
+	// evaluation. Nil means time.Now; set it to get deterministic results in
+	// tests.
+	Clock func() time.Time
+}
+
+// Program is a parsed rule prepared for evaluation. It is safe to evaluate a
+// Program from many goroutines at once.
+type Program struct {
+	nodes   []interface{}
+	options Options
+	root    *groupPlan                   // plan for the top-level nodes
+	plans   map[*parser.Group]*groupPlan // plan for every group in the rule
+}
+
+// NewProgram prepares parsed top-level nodes for evaluation. The nodes must
+// not be modified afterwards.
+func NewProgram(nodes []interface{}, options Options) *Program {
+	p := &Program{
+		nodes:   nodes,
+		options: options,
+		plans:   map[*parser.Group]*groupPlan{},
+	}
+	p.root = p.planGroup(nodes)
+
+	return p
+}
+
+// Compile checks parsed top-level nodes against the Env in the options, like
+// Check, and prepares them for evaluation.
+func Compile(nodes []interface{}, options Options) (*Program, error) {
+	if err := options.env().Check(nodes); err != nil {
+		return nil, err
+	}
+
+	return NewProgram(nodes, options), nil
+}
+
+// Evaluate reports whether every top-level node of the program holds for the
+// context.
+func (p *Program) Evaluate(context map[string]interface{}) (bool, error) {
+	return p.EvaluateWithContext(background, context)
+}
+
+// EvaluateWithContext is like Evaluate, and passes ctx on to custom functions.
+// The evaluation stops with ctx's error when ctx is done before a function is
+// called.
+func (p *Program) EvaluateWithContext(ctx context.Context, data map[string]interface{}) (bool, error) {
+	e := &evaluation{program: p, ctx: ctx, context: data}
+	return e.evaluateChildren("and", p.nodes, p.root, nil)
+}
+
+// Explain evaluates the program like Evaluate and returns a trace of every
+// step. When the evaluation fails, the trace up to the failing condition is
+// returned along with the error.
+func (p *Program) Explain(context map[string]interface{}) (*Trace, error) {
+	root := &Trace{}
+
+	e := &evaluation{program: p, ctx: background, context: context}
+	_, err := e.evaluateChildren("and", p.nodes, p.root, root)
+
+	return root, err
+}
+
+// evaluation holds the state of a single evaluation of a program.
+type evaluation struct {
+	program *Program
+	ctx     context.Context
+	context map[string]interface{}
+	time    time.Time // the time now returns, read from the clock on first use
+}
+
+// background is the context of evaluations that are not given one. It is
+// declared here because the data parameters named context hide the package.
+var background = context.Background()
+
+func (o Options) env() *Env {
+	if o.Env == nil {
+		return defaultEnv
+	}
+
+	return o.Env
+}
@@ -0,0 +1,273 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// Version is a semantic version as defined by SemVer 2.0, like 2.10.0 or
+// 1.0.0-beta.2+build.7. Rules create versions with the version function, and
+// contexts may hold them directly.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	PreRelease []string // dot-separated identifiers after '-', like ["beta", "2"]
+	Build      string   // metadata after '+', which does not affect precedence
+}
+
+// ParseVersion parses a version in SemVer 2.0 syntax: three numbers without
+// leading zeros, optionally followed by a pre-release and build metadata.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	rest := s
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		if !validIdentifiers(v.Build, false) {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+		rest = rest[:i]
+	}
+
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		if !validIdentifiers(pre, true) {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+		v.PreRelease = strings.Split(pre, ".")
+		rest = rest[:i]
+	}
+
+	core := strings.Split(rest, ".")
+	if len(core) != 3 {
+		return Version{}, fmt.Errorf("invalid version '%s'", s)
+	}
+
+	for i, target := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		if !isNumericIdentifier(core[i]) {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+
+		number, err := strconv.ParseUint(core[i], 10, 64)
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version '%s'", s)
+		}
+		*target = number
+	}
+
+	return v, nil
+}
+
+// validIdentifiers reports whether s is a non-empty list of dot-separated
+// identifiers made of ASCII letters, digits and hyphens. Numeric identifiers
+// of a pre-release must not have leading zeros.
+func validIdentifiers(s string, preRelease bool) bool {
+	for _, identifier := range strings.Split(s, ".") {
+		if identifier == "" {
+			return false
+		}
+
+		for _, r := range identifier {
+			if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+
+		if preRelease && isDigits(identifier) && !isNumericIdentifier(identifier) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// isNumericIdentifier reports whether s is a number without leading zeros.
+func isNumericIdentifier(s string) bool {
+	return isDigits(s) && (s == "0" || s[0] != '0')
+}
+
+// Compare returns -1, 0 or +1 depending on whether v precedes, equals or
+// follows w. A pre-release precedes its release, so 1.0.0-rc.1 < 1.0.0, and
+// build metadata is ignored.
+func (v Version) Compare(w Version) int {
+	for _, pair := range [][2]uint64{{v.Major, w.Major}, {v.Minor, w.Minor}, {v.Patch, w.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.PreRelease) == 0 && len(w.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(w.PreRelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(w.PreRelease); i++ {
+		if c := compareIdentifiers(v.PreRelease[i], w.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A larger set of pre-release fields has a higher precedence
+	switch {
+	case len(v.PreRelease) < len(w.PreRelease):
+		return -1
+	case len(v.PreRelease) > len(w.PreRelease):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareIdentifiers compares pre-release identifiers: numeric ones by value,
+// others in ASCII order, and numeric ones before all others.
+func compareIdentifiers(a, b string) int {
+	aNumeric, bNumeric := isDigits(a), isDigits(b)
+
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			// Without leading zeros, a longer number is larger
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.PreRelease) > 0 {
+		s += "-" + strings.Join(v.PreRelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+
+	return s
+}
+
+// toVersion converts a Version, or a string holding one, to a version.
+func toVersion(value interface{}) (Version, error) {
+	switch v := value.(type) {
+	case Version:
+		return v, nil
+	case string:
+		return ParseVersion(v)
+	default:
+		return Version{}, fmt.Errorf("expected a version, got %T", value)
+	}
+}
+
+// isVersion reports whether the values should be compared as versions, which
+// is when one of them is a Version.
+func isVersion(values ...interface{}) bool {
+	for _, value := range values {
+		if _, ok := value.(Version); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareVersions applies an ordering operator to two versions by their
+// SemVer precedence.
+func compareVersions(fieldValue, conditionValue interface{}, operator string, negate bool) (bool, error) {
+	fieldVersion, err := toVersion(fieldValue)
+	if err != nil {
+		return false, err
+	}
+
+	conditionVersion, err := toVersion(conditionValue)
+	if err != nil {
+		return false, err
+	}
+
+	var result bool
+	switch c := fieldVersion.Compare(conditionVersion); operator {
+	case "lt":
+		result = c < 0
+	case "gt":
+		result = c > 0
+	case "lte":
+		result = c <= 0
+	case "gte":
+		result = c >= 0
+	}
+
+	return applyNegation(result, negate), nil
+}
+
+func versionBetween(fieldValue interface{}, values parser.Value, negate bool) (bool, error) {
+	var versions [3]Version
+	for i, value := range []interface{}{fieldValue, values[0], values[1]} {
+		v, err := toVersion(value)
+		if err != nil {
+			return false, err
+		}
+
+		versions[i] = v
+	}
+
+	result := versions[0].Compare(versions[1]) >= 0 && versions[0].Compare(versions[2]) <= 0
+	return applyNegation(result, negate), nil
+}
+
+// versionsEqual compares two values as versions if one of them is a Version.
+// Versions that only differ in build metadata are equal. The second result is
+// false when neither value is a Version.
+func versionsEqual(a, b interface{}) (bool, bool, error) {
+	if !isVersion(a, b) {
+		return false, false, nil
+	}
+
+	aVersion, err := toVersion(a)
+	if err != nil {
+		return false, true, err
+	}
+
+	bVersion, err := toVersion(b)
+	if err != nil {
+		return false, true, err
+	}
+
+	return aVersion.Compare(bVersion) == 0, true, nil
+}
+
+func callVersion(e *evaluation, args []interface{}) (interface{}, error) {
+	return ParseVersion(args[0].(string))
+}
+
+func validateVersion(args []interface{}) error {
+	_, err := ParseVersion(args[0].(string))
+	return err
+}
@@ -0,0 +1,336 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// toTime converts a time.Time, or a string in one of the forms of date/time
+// literals such as an RFC 3339 timestamp, to a time. The second result is false
+// for anything else.
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	case string:
+		if t, err := parser.ParseDateTime(v); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// isTime reports whether the value is a time.Time.
+func isTime(value interface{}) bool {
+	switch value.(type) {
+	case time.Time, *time.Time:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTemporal reports whether the values should be compared as times: one of
+// them is a time.Time, or all of them are strings that hold times. Strings
+// that are not times keep failing as they did before, as non-numbers.
+func isTemporal(values ...interface{}) bool {
+	for _, value := range values {
+		if isTime(value) {
+			return true
+		}
+	}
+
+	for _, value := range values {
+		if _, ok := value.(string); !ok {
+			return false
+		}
+
+		if _, ok := toTime(value); !ok {
+			return false
+		}
+	}
+
+	return len(values) > 0
+}
+
+// compareTimes applies an ordering operator to two times. Times in different
+// zones are compared by the instant they refer to.
+func compareTimes(fieldValue, conditionValue interface{}, operator string, negate bool) (bool, error) {
+	fieldTime, ok := toTime(fieldValue)
+	conditionTime, ok2 := toTime(conditionValue)
+	if !ok || !ok2 {
+		return false, fmt.Errorf("invalid types for time comparison: %T and %T", fieldValue, conditionValue)
+	}
+
+	var result bool
+	switch operator {
+	case "lt", "before":
+		result = fieldTime.Before(conditionTime)
+	case "gt", "after":
+		result = fieldTime.After(conditionTime)
+	case "lte":
+		result = !fieldTime.After(conditionTime)
+	case "gte":
+		result = !fieldTime.Before(conditionTime)
+	}
+
+	return applyNegation(result, negate), nil
+}
+
+func timeBetween(fieldValue interface{}, values parser.Value, negate bool) (bool, error) {
+	fieldTime, ok := toTime(fieldValue)
+	low, lowOk := toTime(values[0])
+	high, highOk := toTime(values[1])
+	if !ok || !lowOk || !highOk {
+		return false, fmt.Errorf("invalid types for 'between' operator")
+	}
+
+	result := !fieldTime.Before(low) && !fieldTime.After(high)
+	return applyNegation(result, negate), nil
+}
+
+// timesEqual compares two values as times if one of them is a time.Time. The
+// second result is false when neither is.
+func timesEqual(a, b interface{}) (bool, bool) {
+	if !isTime(a) && !isTime(b) {
+		return false, false
+	}
+
+	aTime, ok := toTime(a)
+	bTime, ok2 := toTime(b)
+
+	return ok && ok2 && aTime.Equal(bTime), true
+}
+
+// calculateTime handles arithmetic on times: a duration can be added to or
+// subtracted from a time, and subtracting two times gives a duration. The
+// second result is false when neither operand is a time.
+func calculateTime(expr *parser.BinaryExpr, left, right interface{}) (interface{}, bool, error) {
+	if !isTemporal(left) && !isTemporal(right) {
+		return nil, false, nil
+	}
+
+	leftTime, leftIsTime := toTime(left)
+	rightTime, rightIsTime := toTime(right)
+
+	leftDuration, leftIsDuration := left.(time.Duration)
+	rightDuration, rightIsDuration := right.(time.Duration)
+
+	switch {
+	case expr.Op == "+" && leftIsTime && rightIsDuration:
+		return leftTime.Add(rightDuration), true, nil
+	case expr.Op == "+" && leftIsDuration && rightIsTime:
+		return rightTime.Add(leftDuration), true, nil
+	case expr.Op == "-" && leftIsTime && rightIsDuration:
+		return leftTime.Add(-rightDuration), true, nil
+	case expr.Op == "-" && leftIsTime && rightIsTime:
+		return leftTime.Sub(rightTime), true, nil
+	default:
+		return nil, true, fmt.Errorf("invalid types for '%s': %T and %T", expr.Op, left, right)
+	}
+}
+
+// now returns the time of the evaluation. The clock is read once, so every
+// call to now in a rule sees the same time.
+func (e *evaluation) now() time.Time {
+	if e.time.IsZero() {
+		if e.program.options.Clock != nil {
+			e.time = e.program.options.Clock()
+		} else {
+			e.time = time.Now()
+		}
+	}
+
+	return e.time
+}
+
+// toDuration converts a time.Duration, or a number of seconds, to a duration.
+// The second result is false for anything else.
+func toDuration(value interface{}) (time.Duration, bool, error) {
+	if d, ok := value.(time.Duration); ok {
+		return d, true, nil
+	}
+
+	seconds, ok, err := toFloat64(value)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+
+	d, ok := nanoseconds(seconds * float64(time.Second))
+	if !ok {
+		return 0, true, fmt.Errorf("duration of %g seconds is out of range", seconds)
+	}
+
+	return d, true, nil
+}
+
+// nanoseconds converts a number of nanoseconds to a duration. The second
+// result is false when the number does not fit.
+func nanoseconds(n float64) (time.Duration, bool) {
+	if math.IsNaN(n) || n >= math.MaxInt64 || n < math.MinInt64 {
+		return 0, false
+	}
+
+	return time.Duration(n), true
+}
+
+// isDuration reports whether the values should be compared as durations,
+// which is when one of them is a time.Duration. Numbers compared with a
+// duration are taken as seconds.
+func isDuration(values ...interface{}) bool {
+	for _, value := range values {
+		if _, ok := value.(time.Duration); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareDurations applies an ordering operator to two durations.
+func compareDurations(fieldValue, conditionValue interface{}, operator string, negate bool) (bool, error) {
+	fieldDuration, ok, err := toDuration(fieldValue)
+	if err != nil {
+		return false, err
+	}
+
+	conditionDuration, ok2, err := toDuration(conditionValue)
+	if err != nil {
+		return false, err
+	}
+
+	if !ok || !ok2 {
+		return false, fmt.Errorf("invalid types for duration comparison: %T and %T", fieldValue, conditionValue)
+	}
+
+	var result bool
+	switch operator {
+	case "lt":
+		result = fieldDuration < conditionDuration
+	case "gt":
+		result = fieldDuration > conditionDuration
+	case "lte":
+		result = fieldDuration <= conditionDuration
+	case "gte":
+		result = fieldDuration >= conditionDuration
+	}
+
+	return applyNegation(result, negate), nil
+}
+
+func durationBetween(fieldValue interface{}, values parser.Value, negate bool) (bool, error) {
+	var durations [3]time.Duration
+	for i, value := range []interface{}{fieldValue, values[0], values[1]} {
+		d, ok, err := toDuration(value)
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			return false, fmt.Errorf("invalid types for 'between' operator")
+		}
+
+		durations[i] = d
+	}
+
+	result := durations[0] >= durations[1] && durations[0] <= durations[2]
+	return applyNegation(result, negate), nil
+}
+
+// durationsEqual compares two values as durations if one of them is a
+// time.Duration. The second result is false when neither is.
+func durationsEqual(a, b interface{}) (bool, bool) {
+	if !isDuration(a, b) {
+		return false, false
+	}
+
+	aDuration, ok, err := toDuration(a)
+	if err != nil || !ok {
+		return false, true
+	}
+
+	bDuration, ok, err := toDuration(b)
+	if err != nil || !ok {
+		return false, true
+	}
+
+	return aDuration == bDuration, true
+}
+
+// calculateDuration handles arithmetic on durations: durations can be added
+// and subtracted, multiplied and divided by numbers, and divided by each other,
+// which gives a number. The second result is false when neither operand is a
+// duration.
+func calculateDuration(expr *parser.BinaryExpr, left, right interface{}) (interface{}, bool, error) {
+	if !isDuration(left, right) {
+		return nil, false, nil
+	}
+
+	leftDuration, leftIsDuration := left.(time.Duration)
+	rightDuration, rightIsDuration := right.(time.Duration)
+	leftNumber, leftIsNumber, err := toFloat64(left)
+	if err != nil {
+		return nil, true, err
+	}
+
+	rightNumber, rightIsNumber, err := toFloat64(right)
+	if err != nil {
+		return nil, true, err
+	}
+
+	switch {
+	case leftIsDuration && rightIsDuration:
+		switch expr.Op {
+		case "+":
+			return leftDuration + rightDuration, true, nil
+		case "-":
+			return leftDuration - rightDuration, true, nil
+		case "/", "%":
+			if rightDuration == 0 {
+				return nil, true, fmt.Errorf("division by zero in '%s'", expr)
+			}
+
+			if expr.Op == "%" {
+				return leftDuration % rightDuration, true, nil
+			}
+
+			return float64(leftDuration) / float64(rightDuration), true, nil
+		}
+	case leftIsDuration && rightIsNumber:
+		switch expr.Op {
+		case "*":
+			return scaledDuration(expr, float64(leftDuration)*rightNumber)
+		case "/":
+			if rightNumber == 0 {
+				return nil, true, fmt.Errorf("division by zero in '%s'", expr)
+			}
+
+			return scaledDuration(expr, float64(leftDuration)/rightNumber)
+		}
+	case leftIsNumber && rightIsDuration:
+		if expr.Op == "*" {
+			return scaledDuration(expr, leftNumber*float64(rightDuration))
+		}
+	}
+
+	return nil, true, fmt.Errorf("invalid types for '%s': %T and %T", expr.Op, left, right)
+}
+
+// scaledDuration returns the result of multiplying or dividing a duration by
+// a number, given in nanoseconds.
+func scaledDuration(expr *parser.BinaryExpr, n float64) (interface{}, bool, error) {
+	d, ok := nanoseconds(n)
+	if !ok {
+		return nil, true, fmt.Errorf("duration is out of range in '%s'", expr)
+	}
+
+	return d, true, nil
+}
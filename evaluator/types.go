@@ -0,0 +1,178 @@
+package evaluator
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/alicavdar/logix/parser"
+)
+
+// Type is the type of a function parameter or result.
+type Type int
+
+const (
+	// TypeAny accepts any value, including nil.
+	TypeAny Type = iota
+	// TypeString is a string.
+	TypeString
+	// TypeNumber is a number of any Go numeric type. Functions always
+	// receive numbers as float64.
+	TypeNumber
+	// TypeBool is a bool.
+	TypeBool
+	// TypeArray is an array. Functions receive it as []interface{}.
+	TypeArray
+	// TypeMap is a map[string]interface{}.
+	TypeMap
+	// TypeTime is a time.Time.
+	TypeTime
+	// TypeDuration is a time.Duration.
+	TypeDuration
+	// TypeVersion is a Version.
+	TypeVersion
+	// TypeIP is an IP address, a netip.Addr.
+	TypeIP
+)
+
+// String describes the type the way error messages refer to it, like "a
+// string".
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "a string"
+	case TypeNumber:
+		return "a number"
+	case TypeBool:
+		return "a boolean"
+	case TypeArray:
+		return "an array"
+	case TypeMap:
+		return "a map"
+	case TypeTime:
+		return "a time"
+	case TypeDuration:
+		return "a duration"
+	case TypeVersion:
+		return "a version"
+	case TypeIP:
+		return "an IP address"
+	default:
+		return "any value"
+	}
+}
+
+// convert checks that the value has the type and returns it in the form
+// functions receive it.
+func (t Type) convert(value interface{}) (interface{}, error) {
+	switch t {
+	case TypeAny:
+		return value, nil
+	case TypeNumber:
+		number, ok, err := toFloat64(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return number, nil
+		}
+	case TypeArray:
+		if list, ok := toList(value); ok {
+			return list, nil
+		}
+	case TypeString:
+		if _, ok := value.(string); ok {
+			return value, nil
+		}
+	case TypeBool:
+		if _, ok := value.(bool); ok {
+			return value, nil
+		}
+	case TypeMap:
+		if _, ok := value.(map[string]interface{}); ok {
+			return value, nil
+		}
+	case TypeTime:
+		if _, ok := value.(time.Time); ok {
+			return value, nil
+		}
+	case TypeDuration:
+		if _, ok := value.(time.Duration); ok {
+			return value, nil
+		}
+	case TypeVersion:
+		if _, ok := value.(Version); ok {
+			return value, nil
+		}
+	case TypeIP:
+		if _, ok := value.(netip.Addr); ok {
+			return value, nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected %s, got %T", t, value)
+}
+
+// staticType returns the type an expression is known to have before it is
+// evaluated, or TypeAny if it depends on the context.
+func (env *Env) staticType(expr parser.Expr) Type {
+	switch expr := expr.(type) {
+	case *parser.Literal:
+		switch expr.Value.(type) {
+		case string:
+			return TypeString
+		case float64:
+			return TypeNumber
+		case bool:
+			return TypeBool
+		case time.Time:
+			return TypeTime
+		case time.Duration:
+			return TypeDuration
+		}
+	case *parser.CallExpr:
+		if fn, ok := env.function(expr.Func); ok {
+			return fn.result
+		}
+	case *parser.UnaryExpr:
+		if env.staticType(expr.Operand) == TypeDuration {
+			return TypeDuration
+		}
+
+		return TypeNumber
+	case *parser.BinaryExpr:
+		return arithmeticType(expr.Op, env.staticType(expr.Left), env.staticType(expr.Right))
+	}
+
+	return TypeAny
+}
+
+// arithmeticType returns the type of an arithmetic operation on operands of
+// the given types. Operands of unknown type are taken to be numbers, unless
+// the other operand is a time or a duration, which leaves the result unknown
+// as well.
+func arithmeticType(op string, left, right Type) Type {
+	temporal := func(t Type) bool { return t == TypeTime || t == TypeDuration }
+	if !temporal(left) && !temporal(right) {
+		return TypeNumber
+	}
+
+	switch {
+	case left == TypeTime && right == TypeTime && op == "-":
+		return TypeDuration
+	case left == TypeTime && right == TypeDuration && (op == "+" || op == "-"):
+		return TypeTime
+	case left == TypeDuration && right == TypeTime && op == "+":
+		return TypeTime
+	case left == TypeDuration && right == TypeDuration && op == "/":
+		return TypeNumber
+	case left == TypeAny || right == TypeAny:
+		// 7d + created_at is a time if created_at is one
+		return TypeAny
+	case left == TypeDuration && right != TypeTime, right == TypeDuration && left != TypeTime && op == "*":
+		return TypeDuration
+	default:
+		return TypeAny
+	}
+}
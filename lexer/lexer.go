@@ -1,78 +1,164 @@
 package lexer
 
 import (
+	"fmt"
 	"strings"
 	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type TokenKind string
 
 const (
-	EOF         TokenKind = "EOF"
-	IDENT       TokenKind = "IDENT"
-	EQ          TokenKind = "EQ"
-	NEQ         TokenKind = "NEQ"
-	GT          TokenKind = "GT"
-	GTE         TokenKind = "GTE"
-	LT          TokenKind = "LT"
-	LTE         TokenKind = "LTE"
-	CONTAINS    TokenKind = "CONTAINS"
-	BETWEEN     TokenKind = "BETWEEN"
-	IN          TokenKind = "IN"
-	NOT         TokenKind = "NOT"
-	STRING      TokenKind = "STRING"
-	NUMBER      TokenKind = "NUMBER"
-	NIL         TokenKind = "NIL"
-	STARTS_WITH TokenKind = "STARTS_WITH"
-	ENDS_WITH   TokenKind = "ENDS_WITH"
-	LSQUARE     TokenKind = "LSQUARE"
-	RSQUARE     TokenKind = "RSQUARE"
-	COMMA       TokenKind = "COMMA"
-	GROUP       TokenKind = "GROUP"
-	AND         TokenKind = "AND"
-	OR          TokenKind = "OR"
-	INDENT      TokenKind = "INDENT"
-	DEDENT      TokenKind = "DEDENT"
-	ILLEGAL     TokenKind = "ILLEGAL"
-	TRUE        TokenKind = "TRUE"
-	FALSE       TokenKind = "FALSE"
+	EOF            TokenKind = "EOF"
+	IDENT          TokenKind = "IDENT"
+	EQ             TokenKind = "EQ"
+	NEQ            TokenKind = "NEQ"
+	GT             TokenKind = "GT"
+	GTE            TokenKind = "GTE"
+	LT             TokenKind = "LT"
+	LTE            TokenKind = "LTE"
+	CONTAINS       TokenKind = "CONTAINS"
+	BETWEEN        TokenKind = "BETWEEN"
+	IN             TokenKind = "IN"
+	NOT            TokenKind = "NOT"
+	STRING         TokenKind = "STRING"
+	NUMBER         TokenKind = "NUMBER"
+	NIL            TokenKind = "NIL"
+	STARTS_WITH    TokenKind = "STARTS_WITH"
+	ENDS_WITH      TokenKind = "ENDS_WITH"
+	MATCHES        TokenKind = "MATCHES"
+	IEQ            TokenKind = "IEQ"
+	ICONTAINS      TokenKind = "ICONTAINS"
+	ISTARTS_WITH   TokenKind = "ISTARTS_WITH"
+	IENDS_WITH     TokenKind = "IENDS_WITH"
+	IIN            TokenKind = "IIN"
+	CONTAINS_ANY   TokenKind = "CONTAINS_ANY"
+	CONTAINS_ALL   TokenKind = "CONTAINS_ALL"
+	SUBSET_OF      TokenKind = "SUBSET_OF"
+	INTERSECTS     TokenKind = "INTERSECTS"
+	IN_CIDR        TokenKind = "IN_CIDR"
+	IS_PRIVATE     TokenKind = "IS_PRIVATE"
+	IS_LOOPBACK    TokenKind = "IS_LOOPBACK"
+	WITHIN_RADIUS  TokenKind = "WITHIN_RADIUS"
+	INSIDE_POLYGON TokenKind = "INSIDE_POLYGON"
+	DATETIME       TokenKind = "DATETIME"
+	DURATION       TokenKind = "DURATION"
+	LSQUARE        TokenKind = "LSQUARE"
+	RSQUARE        TokenKind = "RSQUARE"
+	COMMA          TokenKind = "COMMA"
+	LPAREN         TokenKind = "LPAREN"
+	RPAREN         TokenKind = "RPAREN"
+	PLUS           TokenKind = "PLUS"
+	MINUS          TokenKind = "MINUS"
+	STAR           TokenKind = "STAR"
+	SLASH          TokenKind = "SLASH"
+	PERCENT        TokenKind = "PERCENT"
+	GROUP          TokenKind = "GROUP"
+	AND            TokenKind = "AND"
+	OR             TokenKind = "OR"
+	INDENT         TokenKind = "INDENT"
+	DEDENT         TokenKind = "DEDENT"
+	ILLEGAL        TokenKind = "ILLEGAL"
+	TRUE           TokenKind = "TRUE"
+	FALSE          TokenKind = "FALSE"
 )
 
 var keywords = map[string]TokenKind{
-	"eq":         EQ,
-	"neq":        NEQ,
-	"gt":         GT,
-	"lt":         LT,
-	"gte":        GTE,
-	"lte":        LTE,
-	"contains":   CONTAINS,
-	"between":    BETWEEN,
-	"not":        NOT,
-	"nil":        NIL,
-	"startsWith": STARTS_WITH,
-	"endsWith":   ENDS_WITH,
-	"in":         IN,
-	"true":       TRUE,
-	"false":      FALSE,
-	"group":      GROUP,
-	"and":        AND,
-	"or":         OR,
+	"eq":            EQ,
+	"neq":           NEQ,
+	"gt":            GT,
+	"lt":            LT,
+	"gte":           GTE,
+	"lte":           LTE,
+	"contains":      CONTAINS,
+	"between":       BETWEEN,
+	"not":           NOT,
+	"nil":           NIL,
+	"startsWith":    STARTS_WITH,
+	"endsWith":      ENDS_WITH,
+	"matches":       MATCHES,
+	"ieq":           IEQ,
+	"icontains":     ICONTAINS,
+	"istartsWith":   ISTARTS_WITH,
+	"iendsWith":     IENDS_WITH,
+	"iin":           IIN,
+	"containsAny":   CONTAINS_ANY,
+	"containsAll":   CONTAINS_ALL,
+	"subsetOf":      SUBSET_OF,
+	"intersects":    INTERSECTS,
+	"inCidr":        IN_CIDR,
+	"isPrivate":     IS_PRIVATE,
+	"isLoopback":    IS_LOOPBACK,
+	"withinRadius":  WITHIN_RADIUS,
+	"insidePolygon": INSIDE_POLYGON,
+	"in":            IN,
+	"true":          TRUE,
+	"false":         FALSE,
+	"group":         GROUP,
+	"and":           AND,
+	"or":            OR,
+}
+
+var arithmeticOperators = map[rune]TokenKind{
+	'+': PLUS,
+	'-': MINUS,
+	'*': STAR,
+	'/': SLASH,
+	'%': PERCENT,
+}
+
+// contextualOperators are operators and quantifiers that are lexed as
+// identifiers, so that rules which use them as field names, like
+// `before eq 1` or `all eq 1`, keep working. The parser reads them as
+// operators where an operator is expected, and as quantifiers where they are
+// followed by a field.
+var contextualOperators = map[string]bool{
+	"before":  true,
+	"after":   true,
+	"exists":  true,
+	"isEmpty": true,
+	"any":     true,
+	"all":     true,
+	"none":    true,
+}
+
+// IsKeyword reports whether the word is reserved by Logix, such as an operator
+// or a literal like true.
+func IsKeyword(word string) bool {
+	_, ok := keywords[word]
+	return ok || contextualOperators[word]
+}
+
+// Position is a location in the Logix source. Offset is a byte offset
+// starting at 0, lines and columns start at 1.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
 }
 
 type Token struct {
 	Kind   TokenKind
 	Lexeme string
+	Pos    Position // where the token starts
+	End    Position // just past the last character of the token
 }
 
 type Lexer struct {
-	input        string // the entire input string being lexed
-	position     int    // current position (points to the current char)
-	readPosition int    // the next position (used for lookahead)
-	ch           rune   // the current char being processed
-	indentWidth  int    // the number of spaces or tabs that represent one level of indentation
-	useSpaces    bool   // whether the input is using spaces for indentation (spaces: true, tabs: false)
-	indentStack  []int  // stack to track the current indentation levels (used for handling nested blocks)
-	dedentCount  int    // count of DEDENT tokens pending to be emitted (after reducing indentation levels)
+	input        string    // the entire input string being lexed
+	position     int       // current position (points to the current char)
+	readPosition int       // the next position (used for lookahead)
+	ch           rune      // the current char being processed
+	indentWidth  int       // the number of spaces or tabs that represent one level of indentation
+	useSpaces    bool      // whether the input is using spaces for indentation (spaces: true, tabs: false)
+	indentStack  []int     // stack to track the current indentation levels (used for handling nested blocks)
+	dedentCount  int       // count of DEDENT tokens pending to be emitted (after reducing indentation levels)
+	line         int       // line of the current char
+	column       int       // column of the current char
+	tokenPos     Position  // start position of the token being read
+	lastKind     TokenKind // kind of the last token returned
 }
 
 func NewLexer(input string) *Lexer {
@@ -81,6 +167,7 @@ func NewLexer(input string) *Lexer {
 		indentWidth: -1,
 		indentStack: []int{0},
 		dedentCount: 0,
+		line:        1,
 	}
 
 	l.readRune()
@@ -90,6 +177,7 @@ func NewLexer(input string) *Lexer {
 
 func (l *Lexer) Next() Token {
 	l.setIndentationMode()
+	l.tokenPos = l.currentPosition()
 
 	if l.dedentCount > 0 {
 		l.dedentCount--
@@ -113,6 +201,7 @@ func (l *Lexer) Next() Token {
 	}
 
 	l.skipWhitespace()
+	l.tokenPos = l.currentPosition()
 
 	// Logix only supports comments with # and we ignore all comments here
 	if l.ch == '#' {
@@ -123,7 +212,7 @@ func (l *Lexer) Next() Token {
 		return l.Next()
 	}
 
-	if l.ch == '"' {
+	if l.ch == '"' || l.ch == '\'' || l.ch == '`' {
 		return l.readStringToken()
 	} else if l.ch == '[' {
 		l.readRune()
@@ -134,12 +223,45 @@ func (l *Lexer) Next() Token {
 	} else if l.ch == ',' {
 		l.readRune()
 		return l.newToken(COMMA, ",")
+	} else if l.ch == '(' {
+		l.readRune()
+		return l.newToken(LPAREN, "(")
+	} else if l.ch == ')' {
+		l.readRune()
+		return l.newToken(RPAREN, ")")
 	} else if l.isAlpha(l.ch) {
 		var lexeme = l.readLexeme()
 		return l.newToken(l.lookupKeyword(lexeme), lexeme)
-	} else if l.isDigit(l.ch) {
-		return l.newToken(NUMBER, l.readNumber())
+	} else if l.isDigit(l.ch) && l.atDate() {
+		return l.newToken(DATETIME, l.readDateTime())
+	} else if l.isDigit(l.ch) || (l.ch == '-' || l.ch == '+') && l.isDigit(l.peek()) && !l.afterOperand() {
+		number, ok := l.readNumber()
+		if !ok {
+			return l.newToken(ILLEGAL, "Malformed number '"+number+"'")
+		}
+
+		if unit := l.readDurationUnit(); unit != "" {
+			duration, ok := l.readDuration(number + unit)
+			if !ok {
+				return l.newToken(ILLEGAL, "Malformed duration '"+duration+"'")
+			}
+
+			return l.newToken(DURATION, duration)
+		}
+
+		return l.newToken(NUMBER, number)
+	} else if kind, ok := arithmeticOperators[l.ch]; ok {
+		lexeme := string(l.ch)
+		l.readRune()
+		return l.newToken(kind, lexeme)
 	} else if l.ch == 0 {
+		// Blocks that are still open at the end of the input are closed
+		if len(l.indentStack) > 1 {
+			l.dedentCount = len(l.indentStack) - 2
+			l.indentStack = l.indentStack[:1]
+			return l.newToken(DEDENT, "")
+		}
+
 		return l.newToken(EOF, "")
 	} else {
 		tok := l.newToken(ILLEGAL, string(l.ch))
@@ -148,22 +270,121 @@ func (l *Lexer) Next() Token {
 	}
 }
 
+// readStringToken reads a string literal. Double and single quoted strings
+// support the escapes \", \', \\, \n, \r, \t and \uXXXX, backtick quoted
+// strings are raw and may span several lines.
 func (l *Lexer) readStringToken() Token {
 	var builder strings.Builder
 
+	quote := l.ch
 	l.readRune() // Skip the opening quote
 
-	for l.ch != '"' && l.ch != 0 {
+	var escapeErr string
+	for l.ch != quote && l.ch != 0 {
+		if l.ch == '\\' && quote != '`' {
+			if err := l.readEscape(&builder); err != "" && escapeErr == "" {
+				escapeErr = err
+			}
+			continue
+		}
+
 		builder.WriteRune(l.ch)
 		l.readRune()
 	}
 
-	if l.ch == '"' {
-		l.readRune() // Skip the closing quote
-		return l.newToken(STRING, builder.String())
+	if l.ch != quote {
+		return l.newToken(ILLEGAL, "Unclosed string")
+	}
+
+	l.readRune() // Skip the closing quote
+
+	if escapeErr != "" {
+		return l.newToken(ILLEGAL, escapeErr)
 	}
 
-	return l.newToken(ILLEGAL, "Unclosed string")
+	return l.newToken(STRING, builder.String())
+}
+
+// readEscape reads an escape sequence starting at the backslash and writes the
+// character it stands for. It returns a description of the problem if the
+// escape sequence is invalid.
+func (l *Lexer) readEscape(builder *strings.Builder) string {
+	l.readRune() // Skip the backslash
+
+	switch l.ch {
+	case '"', '\'', '\\':
+		builder.WriteRune(l.ch)
+	case 'n':
+		builder.WriteRune('\n')
+	case 'r':
+		builder.WriteRune('\r')
+	case 't':
+		builder.WriteRune('\t')
+	case 'u':
+		r, ok := l.readUnicodeEscape()
+		if !ok {
+			return "Invalid unicode escape sequence"
+		}
+
+		if utf16.IsSurrogate(r) {
+			// Characters outside the BMP are written as a surrogate pair, as in JSON
+			if l.ch != '\\' || l.peek() != 'u' {
+				return "Invalid unicode escape sequence"
+			}
+			l.readRune()
+
+			low, ok := l.readUnicodeEscape()
+			r = utf16.DecodeRune(r, low)
+			if !ok || r == unicode.ReplacementChar {
+				return "Invalid unicode escape sequence"
+			}
+		}
+
+		builder.WriteRune(r)
+		return ""
+	case 0:
+		return "Unclosed string"
+	default:
+		invalid := l.ch
+		l.readRune()
+		return fmt.Sprintf("Invalid escape sequence '\\%c'", invalid)
+	}
+
+	l.readRune()
+	return ""
+}
+
+// readUnicodeEscape reads the four hex digits of a \uXXXX escape. The current
+// char is the 'u'.
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	var r rune
+
+	for i := 0; i < 4; i++ {
+		l.readRune()
+
+		digit, ok := hexValue(l.ch)
+		if !ok {
+			return 0, false
+		}
+
+		r = r<<4 | digit
+	}
+	l.readRune()
+
+	return r, true
+}
+
+func hexValue(ch rune) (rune, bool) {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return ch - '0', true
+	case 'a' <= ch && ch <= 'f':
+		return ch - 'a' + 10, true
+	case 'A' <= ch && ch <= 'F':
+		return ch - 'A' + 10, true
+	default:
+		return 0, false
+	}
 }
 
 func (l *Lexer) setIndentationMode() {
@@ -181,13 +402,9 @@ func (l *Lexer) setIndentationMode() {
 	} else {
 		var i int
 		var pos int = l.readPosition
-		for {
-			if unicode.IsSpace(rune(l.input[pos])) {
-				i++
-				pos++
-			} else {
-				break
-			}
+		for pos < len(l.input) && l.input[pos] == ' ' {
+			i++
+			pos++
 		}
 		l.useSpaces = true
 		l.indentWidth = i
@@ -199,7 +416,8 @@ func (l *Lexer) peek() rune {
 		return 0
 	}
 
-	return rune(l.input[l.readPosition])
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
 func (l *Lexer) readIndentLevel() int {
@@ -234,7 +452,24 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) newToken(tokenKind TokenKind, lexeme string) Token {
-	return Token{Kind: tokenKind, Lexeme: lexeme}
+	l.lastKind = tokenKind
+	return Token{Kind: tokenKind, Lexeme: lexeme, Pos: l.tokenPos, End: l.currentPosition()}
+}
+
+// afterOperand reports whether the last token ends an operand, in which case a
+// following - or + is an arithmetic operator rather than the sign of a number:
+// total -5 subtracts, while total gt -5 compares with a negative number.
+func (l *Lexer) afterOperand() bool {
+	switch l.lastKind {
+	case IDENT, NUMBER, STRING, TRUE, FALSE, NIL, RPAREN, RSQUARE, DATETIME, DURATION:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *Lexer) currentPosition() Position {
+	return Position{Offset: l.position, Line: l.line, Column: l.column}
 }
 
 func (l *Lexer) lookupKeyword(lexeme string) TokenKind {
@@ -246,44 +481,237 @@ func (l *Lexer) lookupKeyword(lexeme string) TokenKind {
 }
 
 func (l *Lexer) readRune() {
+	// Already past the end of the input; keep the position at EOF
+	if l.readPosition > len(l.input) {
+		return
+	}
+
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
+	size := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = rune(l.input[l.readPosition])
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += size
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads a number literal such as 42, -5, 0.25, 1e6 or 1_000_000.
+// It reports whether the literal is well formed; a malformed literal is still
+// consumed as a whole so that lexing can continue after it.
+func (l *Lexer) readNumber() (string, bool) {
 	position := l.position
-	for l.isDigit(l.ch) {
+
+	if l.ch == '-' || l.ch == '+' {
 		l.readRune()
 	}
-	return l.input[position:l.position]
+
+	ok := l.readDigits()
+
+	if l.ch == '.' {
+		l.readRune()
+		ok = l.readDigits() && ok
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		l.readRune()
+		if l.ch == '-' || l.ch == '+' {
+			l.readRune()
+		}
+		ok = l.readDigits() && ok
+	}
+
+	// A number must not run straight into other characters, as in 12ab or
+	// 1.2.3, unless they are the unit of a duration like 7d
+	if l.atDurationUnit() {
+		return l.input[position:l.position], ok
+	}
+
+	for l.isAlphaNumeric(l.ch) || l.ch == '.' {
+		ok = false
+		l.readRune()
+	}
+
+	return l.input[position:l.position], ok
 }
 
+// readDigits reads a run of digits in which single underscores may separate
+// digits. It reports whether the run was well formed.
+func (l *Lexer) readDigits() bool {
+	if !l.isDigit(l.ch) {
+		return false
+	}
+
+	for {
+		for l.isDigit(l.ch) {
+			l.readRune()
+		}
+
+		if l.ch != '_' {
+			return true
+		}
+
+		l.readRune()
+		if !l.isDigit(l.ch) {
+			return false
+		}
+	}
+}
+
+// readLexeme reads a keyword or a field path like products[0].tags[*].name.
+// Brackets only belong to the lexeme while they are balanced, so the ']'
+// closing an array literal is not swallowed by a preceding keyword.
 func (l *Lexer) readLexeme() string {
 	position := l.position
+	depth := 0
+
+	for {
+		if l.isAlphaNumeric(l.ch) || l.ch == '.' {
+			l.readRune()
+		} else if l.ch == '[' {
+			depth++
+			l.readRune()
+		} else if l.ch == ']' && depth > 0 {
+			depth--
+			l.readRune()
+		} else if l.ch == '*' && l.input[l.position-1] == '[' {
+			l.readRune()
+		} else {
+			break
+		}
+	}
 
-	for l.isAlphaNumeric(l.ch) || l.ch == '.' || l.ch == '[' || l.ch == ']' {
+	return l.input[position:l.position]
+}
+
+// durationUnits are the units a duration literal like 30s or 7d can have.
+// Longer units come first, so 5ms is not read as 5m followed by s.
+var durationUnits = []string{"ms", "s", "m", "h", "d"}
+
+// atDurationUnit reports whether the input continues with a duration unit that
+// is followed by the next part of a compound duration, like the m in 1m30s, or
+// by no further letters or digits.
+func (l *Lexer) atDurationUnit() bool {
+	return l.durationUnit() != ""
+}
+
+func (l *Lexer) durationUnit() string {
+	rest := l.input[l.position:]
+	for _, unit := range durationUnits {
+		if strings.HasPrefix(rest, unit) {
+			next, _ := utf8.DecodeRuneInString(rest[len(unit):])
+			if l.isDigit(next) || !l.isAlphaNumeric(next) && next != '.' {
+				return unit
+			}
+		}
+	}
+
+	return ""
+}
+
+// readDurationUnit reads the unit that follows the number of a duration
+// literal, if there is one.
+func (l *Lexer) readDurationUnit() string {
+	unit := l.durationUnit()
+	for range unit {
+		l.readRune()
+	}
+
+	return unit
+}
+
+// readDuration reads the remaining parts of a compound duration like 1h30m,
+// after its first number and unit. It reports whether every part has a
+// well-formed number and a unit.
+func (l *Lexer) readDuration(first string) (string, bool) {
+	position := l.position
+	ok := true
+
+	for l.isDigit(l.ch) {
+		ok = l.readDigits() && ok
+		if l.ch == '.' {
+			l.readRune()
+			ok = l.readDigits() && ok
+		}
+
+		if l.readDurationUnit() == "" {
+			for l.isAlphaNumeric(l.ch) || l.ch == '.' {
+				l.readRune()
+			}
+
+			return first + l.input[position:l.position], false
+		}
+	}
+
+	return first + l.input[position:l.position], ok
+}
+
+// atDate reports whether the input continues with a date like 2024-01-31.
+func (l *Lexer) atDate() bool {
+	rest := l.input[l.position:]
+	if len(rest) < 10 {
+		return false
+	}
+
+	for i := 0; i < 10; i++ {
+		if i == 4 || i == 7 {
+			if rest[i] != '-' {
+				return false
+			}
+		} else if !l.isDigit(rune(rest[i])) {
+			return false
+		}
+	}
+
+	return len(rest) == 10 || !l.isDigit(rune(rest[10]))
+}
+
+// readDateTime reads a date literal like 2024-01-31, optionally followed by a
+// time and a zone as in RFC 3339: 2024-01-31T09:30:00Z. The literal is only
+// validated by the parser.
+func (l *Lexer) readDateTime() string {
+	position := l.position
+	for i := 0; i < 10; i++ {
 		l.readRune()
 	}
 
+	if l.ch == 'T' && l.isDigit(l.peek()) {
+		l.readRune()
+		for l.isDigit(l.ch) || l.ch == ':' || l.ch == '.' {
+			l.readRune()
+		}
+
+		if l.ch == 'Z' {
+			l.readRune()
+		} else if (l.ch == '+' || l.ch == '-') && l.isDigit(l.peek()) {
+			l.readRune()
+			for l.isDigit(l.ch) || l.ch == ':' {
+				l.readRune()
+			}
+		}
+	}
+
 	return l.input[position:l.position]
 }
 
 func (l *Lexer) isAlphaNumeric(ch rune) bool {
-	return l.isAlpha(ch) || l.isDigit(ch)
+	return l.isAlpha(ch) || unicode.IsDigit(ch)
 }
 
 func (l *Lexer) isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// isAlpha reports whether ch can start a field name or keyword. Any Unicode
+// letter is accepted so that fields can be named in any language.
 func (l *Lexer) isAlpha(ch rune) bool {
-	return 'a' <= ch && ch <= 'z' ||
-		'A' <= ch && ch <= 'Z' ||
-		ch == '_'
+	return unicode.IsLetter(ch) || ch == '_'
 }
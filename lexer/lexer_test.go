@@ -14,7 +14,7 @@ func runLexerTests(t *testing.T, tests []lexerTest) {
 		lexer := NewLexer(test.input)
 		for i, expected := range test.expectedTokens {
 			token := lexer.Next()
-			if token != expected {
+			if token.Kind != expected.Kind || token.Lexeme != expected.Lexeme {
 				t.Errorf("Test case %d failed at token %d: expected %v, got %v", idx, i, expected, token)
 			}
 		}
@@ -153,6 +153,471 @@ group and # This is a comment
 				{Kind: EOF, Lexeme: ""},
 			},
 		},
+		// Blocks still open at the end of the input are closed before EOF
+		{
+			input: "group and\n    group or\n        a eq 1",
+			expectedTokens: []Token{
+				{Kind: GROUP, Lexeme: "group"},
+				{Kind: AND, Lexeme: "and"},
+				{Kind: INDENT, Lexeme: ""},
+				{Kind: GROUP, Lexeme: "group"},
+				{Kind: OR, Lexeme: "or"},
+				{Kind: INDENT, Lexeme: ""},
+				{Kind: IDENT, Lexeme: "a"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: NUMBER, Lexeme: "1"},
+				{Kind: DEDENT, Lexeme: ""},
+				{Kind: DEDENT, Lexeme: ""},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `temperature gt -5`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "temperature"},
+				{Kind: GT, Lexeme: "gt"},
+				{Kind: NUMBER, Lexeme: "-5"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `ratio between 0.25 and +1e6`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "ratio"},
+				{Kind: BETWEEN, Lexeme: "between"},
+				{Kind: NUMBER, Lexeme: "0.25"},
+				{Kind: AND, Lexeme: "and"},
+				{Kind: NUMBER, Lexeme: "+1e6"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `amount in [1_000_000, -2.5E-3, 3e+2]`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "amount"},
+				{Kind: IN, Lexeme: "in"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: NUMBER, Lexeme: "1_000_000"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: NUMBER, Lexeme: "-2.5E-3"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: NUMBER, Lexeme: "3e+2"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `a eq 1__0 b eq 1_ c eq 1. d eq 1e e eq 1.2.3 f eq 12ab g eq -x`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "a"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '1__0'"},
+				{Kind: IDENT, Lexeme: "b"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '1_'"},
+				{Kind: IDENT, Lexeme: "c"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '1.'"},
+				{Kind: IDENT, Lexeme: "d"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '1e'"},
+				{Kind: IDENT, Lexeme: "e"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '1.2.3'"},
+				{Kind: IDENT, Lexeme: "f"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '12ab'"},
+				{Kind: IDENT, Lexeme: "g"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: MINUS, Lexeme: "-"},
+				{Kind: IDENT, Lexeme: "x"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `price eq 10
+group and
+    name contains "J"
+`
+	expected := []struct {
+		kind TokenKind
+		pos  Position
+		end  Position
+	}{
+		{IDENT, Position{Offset: 0, Line: 1, Column: 1}, Position{Offset: 5, Line: 1, Column: 6}},
+		{EQ, Position{Offset: 6, Line: 1, Column: 7}, Position{Offset: 8, Line: 1, Column: 9}},
+		{NUMBER, Position{Offset: 9, Line: 1, Column: 10}, Position{Offset: 11, Line: 1, Column: 12}},
+		{GROUP, Position{Offset: 12, Line: 2, Column: 1}, Position{Offset: 17, Line: 2, Column: 6}},
+		{AND, Position{Offset: 18, Line: 2, Column: 7}, Position{Offset: 21, Line: 2, Column: 10}},
+		{INDENT, Position{Offset: 21, Line: 2, Column: 10}, Position{Offset: 25, Line: 3, Column: 4}},
+		{IDENT, Position{Offset: 26, Line: 3, Column: 5}, Position{Offset: 30, Line: 3, Column: 9}},
+		{CONTAINS, Position{Offset: 31, Line: 3, Column: 10}, Position{Offset: 39, Line: 3, Column: 18}},
+		{STRING, Position{Offset: 40, Line: 3, Column: 19}, Position{Offset: 43, Line: 3, Column: 22}},
+		{DEDENT, Position{Offset: 43, Line: 3, Column: 22}, Position{Offset: 43, Line: 3, Column: 22}},
+		{EOF, Position{Offset: 44, Line: 4, Column: 1}, Position{Offset: 44, Line: 4, Column: 1}},
+	}
+
+	lexer := NewLexer(input)
+	for i, exp := range expected {
+		token := lexer.Next()
+		if token.Kind != exp.kind || token.Pos != exp.pos || token.End != exp.end {
+			t.Errorf("Token %d: expected %s at %v-%v, got %s at %v-%v", i, exp.kind, exp.pos, exp.end, token.Kind, token.Pos, token.End)
+		}
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `a eq "say \"hi\"\n\ttab \\ \u00e7 \ud83d\ude00"`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "a"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: STRING, Lexeme: "say \"hi\"\n\ttab \\ ç 😀"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `b eq 'it\'s "quoted"'`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "b"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: STRING, Lexeme: `it's "quoted"`},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: "c eq `raw \\n \"x\" 'y'\nsecond line` d eq 1",
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "c"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: STRING, Lexeme: "raw \\n \"x\" 'y'\nsecond line"},
+				{Kind: IDENT, Lexeme: "d"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: NUMBER, Lexeme: "1"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `a eq "bad \q escape" b eq "\u12" c eq "\ud83d" d eq 'unclosed`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "a"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: `Invalid escape sequence '\q'`},
+				{Kind: IDENT, Lexeme: "b"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Invalid unicode escape sequence"},
+				{Kind: IDENT, Lexeme: "c"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Invalid unicode escape sequence"},
+				{Kind: IDENT, Lexeme: "d"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Unclosed string"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+}
+
+func TestUnicodeInput(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `ürün.başlık eq "Çay bardağı" 価格 lt 1000 größe in ["groß", "日本語"]`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "ürün.başlık"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: STRING, Lexeme: "Çay bardağı"},
+				{Kind: IDENT, Lexeme: "価格"},
+				{Kind: LT, Lexeme: "lt"},
+				{Kind: NUMBER, Lexeme: "1000"},
+				{Kind: IDENT, Lexeme: "größe"},
+				{Kind: IN, Lexeme: "in"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: STRING, Lexeme: "groß"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: STRING, Lexeme: "日本語"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `name eq "x" → 1`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "name"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: STRING, Lexeme: "x"},
+				{Kind: ILLEGAL, Lexeme: "→"},
+				{Kind: NUMBER, Lexeme: "1"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+
+	// Columns count characters, offsets count bytes
+	lexer := NewLexer(`başlık eq "日本" x`)
+	expected := []Position{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 9, Line: 1, Column: 8},
+		{Offset: 12, Line: 1, Column: 11},
+		{Offset: 21, Line: 1, Column: 16},
+	}
+
+	for i, exp := range expected {
+		token := lexer.Next()
+		if token.Pos != exp {
+			t.Errorf("Token %d (%s): expected position %v, got %v", i, token.Lexeme, exp, token.Pos)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `(price * quantity) / 2 % 3 gt -5`,
+			expectedTokens: []Token{
+				{Kind: LPAREN, Lexeme: "("},
+				{Kind: IDENT, Lexeme: "price"},
+				{Kind: STAR, Lexeme: "*"},
+				{Kind: IDENT, Lexeme: "quantity"},
+				{Kind: RPAREN, Lexeme: ")"},
+				{Kind: SLASH, Lexeme: "/"},
+				{Kind: NUMBER, Lexeme: "2"},
+				{Kind: PERCENT, Lexeme: "%"},
+				{Kind: NUMBER, Lexeme: "3"},
+				{Kind: GT, Lexeme: "gt"},
+				{Kind: NUMBER, Lexeme: "-5"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		// After an operand, - and + are operators even when a digit follows
+		{
+			input: `total -5 gte refunded+1`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "total"},
+				{Kind: MINUS, Lexeme: "-"},
+				{Kind: NUMBER, Lexeme: "5"},
+				{Kind: GTE, Lexeme: "gte"},
+				{Kind: IDENT, Lexeme: "refunded"},
+				{Kind: PLUS, Lexeme: "+"},
+				{Kind: NUMBER, Lexeme: "1"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `delta gt -(a - -2)`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "delta"},
+				{Kind: GT, Lexeme: "gt"},
+				{Kind: MINUS, Lexeme: "-"},
+				{Kind: LPAREN, Lexeme: "("},
+				{Kind: IDENT, Lexeme: "a"},
+				{Kind: MINUS, Lexeme: "-"},
+				{Kind: NUMBER, Lexeme: "-2"},
+				{Kind: RPAREN, Lexeme: ")"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+}
+
+func TestTemporalLiterals(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `created_at after now() - 7d`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "created_at"},
+				{Kind: IDENT, Lexeme: "after"},
+				{Kind: IDENT, Lexeme: "now"},
+				{Kind: LPAREN, Lexeme: "("},
+				{Kind: RPAREN, Lexeme: ")"},
+				{Kind: MINUS, Lexeme: "-"},
+				{Kind: DURATION, Lexeme: "7d"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `created_at between 2024-01-01 and 2024-01-31T23:59:59.5+02:00`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "created_at"},
+				{Kind: BETWEEN, Lexeme: "between"},
+				{Kind: DATETIME, Lexeme: "2024-01-01"},
+				{Kind: AND, Lexeme: "and"},
+				{Kind: DATETIME, Lexeme: "2024-01-31T23:59:59.5+02:00"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `expires_at before 2025-06-30T12:00:00Z`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "expires_at"},
+				{Kind: IDENT, Lexeme: "before"},
+				{Kind: DATETIME, Lexeme: "2025-06-30T12:00:00Z"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		// A date needs the full form, anything else is a number followed by
+		// an operator
+		{
+			input: `year eq 2024-1`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "year"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: NUMBER, Lexeme: "2024"},
+				{Kind: MINUS, Lexeme: "-"},
+				{Kind: NUMBER, Lexeme: "1"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+}
+
+func TestDurationLiterals(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `session_length gt 45m`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "session_length"},
+				{Kind: GT, Lexeme: "gt"},
+				{Kind: DURATION, Lexeme: "45m"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `timeout in [250ms, 1.5s, 1h30m, -2d12h]`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "timeout"},
+				{Kind: IN, Lexeme: "in"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: DURATION, Lexeme: "250ms"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: DURATION, Lexeme: "1.5s"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: DURATION, Lexeme: "1h30m"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: DURATION, Lexeme: "-2d12h"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `a eq 5m3 b eq 3mins c eq 1h_`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "a"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed duration '5m3'"},
+				{Kind: IDENT, Lexeme: "b"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '3mins'"},
+				{Kind: IDENT, Lexeme: "c"},
+				{Kind: EQ, Lexeme: "eq"},
+				{Kind: ILLEGAL, Lexeme: "Malformed number '1h_'"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+	}
+
+	runLexerTests(t, tests)
+}
+
+func TestWildcardPaths(t *testing.T) {
+	tests := []lexerTest{
+		{
+			input: `all orders[*].items[*].sku in ["A", "B"]`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "all"},
+				{Kind: IDENT, Lexeme: "orders[*].items[*].sku"},
+				{Kind: IN, Lexeme: "in"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: STRING, Lexeme: "A"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: STRING, Lexeme: "B"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		// A keyword right before the closing bracket of an array
+		{
+			input: `flags in [false, true]`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "flags"},
+				{Kind: IN, Lexeme: "in"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: FALSE, Lexeme: "false"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: TRUE, Lexeme: "true"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `client_ip not inCidr ["10.0.0.0/8"] isPrivate isLoopback`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "client_ip"},
+				{Kind: NOT, Lexeme: "not"},
+				{Kind: IN_CIDR, Lexeme: "inCidr"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: STRING, Lexeme: "10.0.0.0/8"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: IS_PRIVATE, Lexeme: "isPrivate"},
+				{Kind: IS_LOOPBACK, Lexeme: "isLoopback"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `location withinRadius [52.52, 13.405, 5] insidePolygon [[1, 2]]`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "location"},
+				{Kind: WITHIN_RADIUS, Lexeme: "withinRadius"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: NUMBER, Lexeme: "52.52"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: NUMBER, Lexeme: "13.405"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: NUMBER, Lexeme: "5"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: INSIDE_POLYGON, Lexeme: "insidePolygon"},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: LSQUARE, Lexeme: "["},
+				{Kind: NUMBER, Lexeme: "1"},
+				{Kind: COMMA, Lexeme: ","},
+				{Kind: NUMBER, Lexeme: "2"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: RSQUARE, Lexeme: "]"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
+		{
+			input: `len(items) gte 3`,
+			expectedTokens: []Token{
+				{Kind: IDENT, Lexeme: "len"},
+				{Kind: LPAREN, Lexeme: "("},
+				{Kind: IDENT, Lexeme: "items"},
+				{Kind: RPAREN, Lexeme: ")"},
+				{Kind: GTE, Lexeme: "gte"},
+				{Kind: NUMBER, Lexeme: "3"},
+				{Kind: EOF, Lexeme: ""},
+			},
+		},
 	}
 
 	runLexerTests(t, tests)
@@ -0,0 +1,179 @@
+package logix
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicavdar/logix/evaluator"
+	"github.com/alicavdar/logix/parser"
+)
+
+func TestCompileAndEvaluate(t *testing.T) {
+	rule, err := Compile(`
+group and
+    price gt 100
+    status eq "active"
+    group or
+        category in ["electronics", "furniture"]
+        stock between 50 and 100
+`)
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	tests := []struct {
+		context  map[string]interface{}
+		expected bool
+	}{
+		{
+			context: map[string]interface{}{
+				"price":    120.0,
+				"status":   "active",
+				"category": "electronics",
+				"stock":    10.0,
+			},
+			expected: true,
+		},
+		{
+			context: map[string]interface{}{
+				"price":    90.0,
+				"status":   "active",
+				"category": "electronics",
+				"stock":    10.0,
+			},
+			expected: false,
+		},
+		{
+			context: map[string]interface{}{
+				"price":    120.0,
+				"status":   "active",
+				"category": "toys",
+				"stock":    75.0,
+			},
+			expected: true,
+		},
+	}
+
+	// The same rule must give the same answers when it is evaluated repeatedly
+	for round := 0; round < 2; round++ {
+		for i, tt := range tests {
+			result, err := rule.Evaluate(tt.context)
+			if err != nil {
+				t.Fatalf("Round %d, case %d: did not expect an error but got: %v", round, i, err)
+			}
+			if result != tt.expected {
+				t.Errorf("Round %d, case %d: expected %v, got %v", round, i, tt.expected, result)
+			}
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	_, err := Compile(`
+group xor
+    price gt 100
+`)
+	if err == nil {
+		t.Fatalf("Expected an error but got none")
+	}
+}
+
+func TestCompileChecksFunctions(t *testing.T) {
+	_, err := Compile(`lenght(items) gt 1`)
+
+	var errs parser.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Expected a parser.ErrorList with one error, got %v", err)
+	}
+	if errs[0].Message != "unknown function 'lenght'" {
+		t.Errorf("Unexpected error: %v", errs[0])
+	}
+}
+
+func TestCompileWithEnv(t *testing.T) {
+	env := evaluator.NewEnv()
+	err := env.RegisterOperator("isPalindrome", evaluator.Operator{
+		Negatable: true,
+		Eval: func(field interface{}, values []interface{}) (bool, error) {
+			s, _ := field.(string)
+			for i := 0; i < len(s)/2; i++ {
+				if s[i] != s[len(s)-1-i] {
+					return false, nil
+				}
+			}
+
+			return true, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	rule, err := CompileWithOptions("word isPalindrome\nother not isPalindrome", evaluator.Options{Env: env})
+	if err != nil {
+		t.Fatalf("Did not expect an error but got: %v", err)
+	}
+
+	result, err := rule.Evaluate(map[string]interface{}{"word": "level", "other": "logix"})
+	if err != nil || !result {
+		t.Errorf("Expected true, got %v, %v", result, err)
+	}
+
+	// Another Env, or none at all, does not have the operator
+	if _, err := CompileWithOptions("word isPalindrome", evaluator.Options{Env: evaluator.NewEnv()}); err == nil {
+		t.Errorf("Expected an error but got none")
+	}
+}
+
+func TestRuleConcurrentEvaluate(t *testing.T) {
+	for _, order := range []evaluator.Order{evaluator.OrderAsWritten, evaluator.OrderByCost, evaluator.OrderBySelectivity} {
+		rule, err := CompileWithOptions(`
+group or
+    products[0].info.title eq "Smartphone"
+    price lt 10
+`, evaluator.Options{Order: order})
+		if err != nil {
+			t.Fatalf("Did not expect an error but got: %v", err)
+		}
+
+		testConcurrentEvaluate(t, rule)
+	}
+}
+
+func testConcurrentEvaluate(t *testing.T, rule *Rule) {
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			title := "Tablet"
+			if i%2 == 0 {
+				title = "Smartphone"
+			}
+
+			context := map[string]interface{}{
+				"price": 50.0,
+				"products": []interface{}{
+					map[string]interface{}{
+						"info": map[string]interface{}{"title": title},
+					},
+				},
+			}
+
+			for j := 0; j < 100; j++ {
+				result, err := rule.Evaluate(context)
+				if err != nil {
+					t.Errorf("Did not expect an error but got: %v", err)
+					return
+				}
+				if result != (i%2 == 0) {
+					t.Errorf("Goroutine %d: expected %v, got %v", i, i%2 == 0, result)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
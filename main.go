@@ -4,17 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-
-	"github.com/alicavdar/logix/evaluator"
-	"github.com/alicavdar/logix/lexer"
-	"github.com/alicavdar/logix/parser"
 )
 
 func EvaluateLogix(logixContent string, context map[string]interface{}) (bool, error) {
-	lex := lexer.NewLexer(logixContent)
-	pr := parser.NewParser(lex)
+	rule, err := Compile(logixContent)
+	if err != nil {
+		return false, err
+	}
 
-	return evaluator.Evaluate(pr, context)
+	return rule.Evaluate(context)
 }
 
 func LoadContextFromFile(filepath string) (map[string]interface{}, error) {
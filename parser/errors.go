@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alicavdar/logix/lexer"
+)
+
+// ParseError describes a single problem found while parsing Logix source.
+type ParseError struct {
+	Pos      lexer.Position
+	Token    lexer.Token       // the offending token
+	Expected []lexer.TokenKind // the token kinds that would have been accepted, if known
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// ErrorList is the list of every ParseError found in a source.
+type ErrorList []*ParseError
+
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Err returns the list as an error, or nil if the list is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+
+	return l
+}
+
+func describeToken(token lexer.Token) string {
+	switch token.Kind {
+	case lexer.EOF, lexer.DEDENT, lexer.INDENT:
+		return string(token.Kind)
+	default:
+		return fmt.Sprintf("%s '%s'", token.Kind, token.Lexeme)
+	}
+}
+
+// illegalMessage explains an ILLEGAL token. The lexer sets the lexeme of such
+// a token either to the unexpected character or to a description of the
+// problem, like "Unclosed string".
+func illegalMessage(token lexer.Token) string {
+	if utf8.RuneCountInString(token.Lexeme) <= 1 {
+		return fmt.Sprintf("unexpected character '%s'", token.Lexeme)
+	}
+
+	first, size := utf8.DecodeRuneInString(token.Lexeme)
+	return string(unicode.ToLower(first)) + token.Lexeme[size:]
+}
+
+func joinKinds(kinds []lexer.TokenKind) string {
+	names := make([]string, len(kinds))
+	for i, kind := range kinds {
+		names[i] = string(kind)
+	}
+
+	if len(names) == 1 {
+		return names[0]
+	}
+
+	return "one of " + strings.Join(names, ", ")
+}
@@ -0,0 +1,279 @@
+package parser
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alicavdar/logix/lexer"
+)
+
+// Expr is an expression that computes a value, like len(items) or
+// price * quantity. A condition whose left-hand side is a plain field path has
+// no Expr and only uses its Field.
+type Expr interface {
+	String() string
+	exprNode() // only the nodes of this package are expressions, not any Stringer
+}
+
+// FieldRef refers to a value in the context by its path.
+type FieldRef struct {
+	Path string
+	Pos  lexer.Position
+	End  lexer.Position
+}
+
+// Literal is a constant inside an expression, like the 2 in price * 2.
+type Literal struct {
+	Value SingleValue
+	Pos   lexer.Position
+	End   lexer.Position
+}
+
+// CallExpr calls a function, like len(items).
+type CallExpr struct {
+	Func string
+	Args []Expr
+	Pos  lexer.Position // start of the function name
+	End  lexer.Position // end of the closing parenthesis
+}
+
+// UnaryExpr applies a sign to its operand, like -delta.
+type UnaryExpr struct {
+	Op      string // "-" or "+"
+	Operand Expr
+	Pos     lexer.Position
+	End     lexer.Position
+}
+
+// BinaryExpr is an arithmetic operation, like price * quantity.
+type BinaryExpr struct {
+	Op    string // "+", "-", "*", "/" or "%"
+	Left  Expr
+	Right Expr
+	Pos   lexer.Position // start of the left operand
+	End   lexer.Position // end of the right operand
+}
+
+func (*FieldRef) exprNode()   {}
+func (*Literal) exprNode()    {}
+func (*CallExpr) exprNode()   {}
+func (*UnaryExpr) exprNode()  {}
+func (*BinaryExpr) exprNode() {}
+
+func (f *FieldRef) String() string {
+	return f.Path
+}
+
+func (l *Literal) String() string {
+	return FormatValue(l.Value)
+}
+
+func (c *CallExpr) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+
+	return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (u *UnaryExpr) String() string {
+	if _, ok := u.Operand.(*BinaryExpr); ok {
+		return u.Op + "(" + u.Operand.String() + ")"
+	}
+
+	return u.Op + u.Operand.String()
+}
+
+// String formats the expression with the parentheses its precedence needs, so
+// (a + b) * c keeps them while a + (b * c) becomes a + b * c.
+func (b *BinaryExpr) String() string {
+	return formatOperand(b.Left, precedence(b.Op), false) + " " + b.Op + " " + formatOperand(b.Right, precedence(b.Op), true)
+}
+
+func formatOperand(operand Expr, parent int, right bool) string {
+	if binary, ok := operand.(*BinaryExpr); ok {
+		// Operators are left-associative, so a right operand of the same
+		// precedence needs parentheses: a - (b - c)
+		if prec := precedence(binary.Op); prec < parent || right && prec == parent {
+			return "(" + binary.String() + ")"
+		}
+	}
+
+	return operand.String()
+}
+
+func precedence(op string) int {
+	switch op {
+	case "*", "/", "%":
+		return 2
+	default:
+		return 1
+	}
+}
+
+// parseExpr parses an arithmetic expression over fields, literals and function
+// calls. * / and % bind tighter than + and -, and all of them are
+// left-associative.
+func (p *Parser) parseExpr() (Expr, bool) {
+	return p.parseBinary(1)
+}
+
+var binaryOperators = map[lexer.TokenKind]int{
+	lexer.PLUS:    1,
+	lexer.MINUS:   1,
+	lexer.STAR:    2,
+	lexer.SLASH:   2,
+	lexer.PERCENT: 2,
+}
+
+// parseBinary parses operands joined by operators of the given precedence or
+// higher.
+func (p *Parser) parseBinary(minPrecedence int) (Expr, bool) {
+	var left Expr
+	var ok bool
+	if minPrecedence < 2 {
+		left, ok = p.parseBinary(minPrecedence + 1)
+	} else {
+		left, ok = p.parseUnary()
+	}
+	if !ok {
+		return nil, false
+	}
+
+	// An operator on the next line starts a new statement, like -delta gt 5
+	for binaryOperators[p.currToken.Kind] == minPrecedence && p.currToken.Pos.Line == p.prevToken.End.Line {
+		op := p.currToken.Lexeme
+		p.nextToken()
+
+		var right Expr
+		if minPrecedence < 2 {
+			right, ok = p.parseBinary(minPrecedence + 1)
+		} else {
+			right, ok = p.parseUnary()
+		}
+		if !ok {
+			return nil, false
+		}
+
+		left = &BinaryExpr{Op: op, Left: left, Right: right, Pos: ExprPos(left), End: p.prevToken.End}
+	}
+
+	return left, true
+}
+
+func (p *Parser) parseUnary() (Expr, bool) {
+	if !p.currTokenIs(lexer.MINUS, lexer.PLUS) {
+		return p.parsePrimary()
+	}
+
+	token := p.currToken
+	p.nextToken()
+
+	operand, ok := p.parseUnary()
+	if !ok {
+		return nil, false
+	}
+
+	// A sign in front of a number or duration is part of the literal
+	if literal, isLiteral := operand.(*Literal); isLiteral {
+		switch value := literal.Value.(type) {
+		case float64:
+			if token.Kind == lexer.MINUS {
+				value = -value
+			}
+
+			return &Literal{Value: value, Pos: token.Pos, End: literal.End}, true
+		case time.Duration:
+			if token.Kind == lexer.MINUS {
+				value = -value
+			}
+
+			return &Literal{Value: value, Pos: token.Pos, End: literal.End}, true
+		}
+	}
+
+	return &UnaryExpr{Op: token.Lexeme, Operand: operand, Pos: token.Pos, End: p.prevToken.End}, true
+}
+
+func (p *Parser) parsePrimary() (Expr, bool) {
+	token := p.currToken
+
+	switch token.Kind {
+	case lexer.IDENT:
+		if p.peekToken.Kind == lexer.LPAREN {
+			return p.parseCall()
+		}
+
+		p.nextToken()
+		return &FieldRef{Path: token.Lexeme, Pos: token.Pos, End: token.End}, true
+	case lexer.LPAREN:
+		p.nextToken()
+
+		expr, ok := p.parseExpr()
+		if !ok {
+			return nil, false
+		}
+
+		if p.currToken.Kind != lexer.RPAREN {
+			p.expectError(lexer.RPAREN)
+			return nil, false
+		}
+		p.nextToken()
+
+		return expr, true
+	case lexer.STRING, lexer.NUMBER, lexer.TRUE, lexer.FALSE, lexer.NIL, lexer.DATETIME, lexer.DURATION:
+		value, ok := p.parseLiteral()
+		if !ok {
+			return nil, false
+		}
+
+		return &Literal{Value: value, Pos: token.Pos, End: token.End}, true
+	default:
+		p.expectError(operandKinds...)
+		return nil, false
+	}
+}
+
+func (p *Parser) parseCall() (Expr, bool) {
+	call := &CallExpr{Func: p.currToken.Lexeme, Args: []Expr{}, Pos: p.currToken.Pos}
+	p.nextToken()
+	p.nextToken()
+
+	for p.currToken.Kind != lexer.RPAREN {
+		arg, ok := p.parseExpr()
+		if !ok {
+			return nil, false
+		}
+		call.Args = append(call.Args, arg)
+
+		if p.currToken.Kind == lexer.COMMA {
+			p.nextToken()
+		} else if p.currToken.Kind != lexer.RPAREN {
+			p.expectError(lexer.COMMA, lexer.RPAREN)
+			return nil, false
+		}
+	}
+	call.End = p.currToken.End
+	p.nextToken()
+
+	return call, true
+}
+
+// ExprPos returns where the expression starts in the source.
+func ExprPos(expr Expr) lexer.Position {
+	switch expr := expr.(type) {
+	case *FieldRef:
+		return expr.Pos
+	case *Literal:
+		return expr.Pos
+	case *CallExpr:
+		return expr.Pos
+	case *UnaryExpr:
+		return expr.Pos
+	case *BinaryExpr:
+		return expr.Pos
+	default:
+		return lexer.Position{}
+	}
+}
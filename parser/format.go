@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String formats the condition back into Logix syntax.
+func (c *Condition) String() string {
+	var builder strings.Builder
+
+	if c.Quantifier != "" {
+		builder.WriteString(c.Quantifier + " ")
+	}
+	builder.WriteString(c.Field)
+	if c.Negate {
+		builder.WriteString(" not")
+	}
+	builder.WriteString(" ")
+	builder.WriteString(c.Operator)
+
+	switch {
+	case c.Operator == "between" && len(c.Value) == 2:
+		builder.WriteString(" " + FormatValue(c.Value[0]) + " and " + FormatValue(c.Value[1]))
+	case len(c.Value) == 1 && !TakesList(c.Operator):
+		builder.WriteString(" " + FormatValue(c.Value[0]))
+	case len(c.Value) > 0 || TakesList(c.Operator):
+		values := make([]string, len(c.Value))
+		for i, value := range c.Value {
+			values[i] = FormatValue(value)
+		}
+		builder.WriteString(" [" + strings.Join(values, ", ") + "]")
+	}
+
+	return builder.String()
+}
+
+// TakesList reports whether the built-in operator takes a list of values,
+// which is written in brackets even when it has a single element.
+func TakesList(op string) bool {
+	switch op {
+	case "withinRadius", "insidePolygon":
+		return true
+	default:
+		return SpreadsList(op)
+	}
+}
+
+// SpreadsList reports whether the built-in operator compares the field with a
+// set of values, so that a single reference to an array field stands for its
+// elements, as in `role in allowed_roles`. The geo operators take a list of
+// coordinates instead and are not spread.
+func SpreadsList(op string) bool {
+	switch op {
+	case "in", "iin", "containsAny", "containsAll", "subsetOf", "intersects", "inCidr":
+		return true
+	default:
+		return false
+	}
+}
+
+// String formats the group header, like "group and".
+func (g *Group) String() string {
+	return "group " + g.LogicalOp
+}
+
+// FormatValue formats a value the way it would be written in Logix, including
+// references to other fields. Arrays from a context are written like list
+// literals and maps like {"key": value}, with their elements formatted the
+// same way. Other values that have no literal form use fmt's default format.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return formatDuration(v)
+	case Expr:
+		return v.String()
+	case Value:
+		values := make([]string, len(v))
+		for i, element := range v {
+			values[i] = FormatValue(element)
+		}
+
+		return "[" + strings.Join(values, ", ") + "]"
+	default:
+		return formatComposite(value)
+	}
+}
+
+// formatComposite formats arrays and maps of any Go type, such as the
+// []interface{} and map[string]interface{} that JSON decodes to.
+func formatComposite(value interface{}) string {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = FormatValue(v.Index(i).Interface())
+		}
+
+		return "[" + strings.Join(values, ", ") + "]"
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			entries = append(entries, FormatValue(iter.Key().Interface())+": "+FormatValue(iter.Value().Interface()))
+		}
+		sort.Strings(entries)
+
+		return "{" + strings.Join(entries, ", ") + "}"
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
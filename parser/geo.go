@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+)
+
+// Polygon is the area of an insidePolygon condition, prepared when the rule is
+// parsed. The bounding box lets the evaluator reject most points without
+// looking at the edges.
+type Polygon struct {
+	Vertices [][2]float64 // latitude and longitude of every corner, in order
+	MinLat   float64
+	MaxLat   float64
+	MinLon   float64
+	MaxLon   float64
+}
+
+// compilePolygon builds a polygon from a literal list of [latitude, longitude]
+// pairs.
+func compilePolygon(value Value) (*Polygon, error) {
+	if len(value) < 3 {
+		return nil, fmt.Errorf("a polygon needs at least 3 corners, got %d", len(value))
+	}
+
+	polygon := &Polygon{
+		MinLat: math.Inf(1),
+		MaxLat: math.Inf(-1),
+		MinLon: math.Inf(1),
+		MaxLon: math.Inf(-1),
+	}
+
+	for i, v := range value {
+		pair, isPair := v.(Value)
+		if !isPair || len(pair) != 2 {
+			return nil, fmt.Errorf("corner %d is not a [latitude, longitude] pair", i+1)
+		}
+
+		lat, latOk := pair[0].(float64)
+		lon, lonOk := pair[1].(float64)
+		if !latOk || !lonOk {
+			return nil, fmt.Errorf("corner %d is not a [latitude, longitude] pair of numbers", i+1)
+		}
+
+		if err := checkCoordinates(lat, lon); err != nil {
+			return nil, fmt.Errorf("corner %d: %v", i+1, err)
+		}
+
+		polygon.Vertices = append(polygon.Vertices, [2]float64{lat, lon})
+		polygon.MinLat = math.Min(polygon.MinLat, lat)
+		polygon.MaxLat = math.Max(polygon.MaxLat, lat)
+		polygon.MinLon = math.Min(polygon.MinLon, lon)
+		polygon.MaxLon = math.Max(polygon.MaxLon, lon)
+	}
+
+	return polygon, nil
+}
+
+// checkRadius checks the [latitude, longitude, radius] of a withinRadius
+// condition, as far as they are literals.
+func checkRadius(value Value) error {
+	if len(value) != 3 {
+		return fmt.Errorf("operator 'withinRadius' expects 3 values [latitude, longitude, radius in km], got %d", len(value))
+	}
+
+	lat, latOk := value[0].(float64)
+	lon, lonOk := value[1].(float64)
+	if latOk && lonOk {
+		if err := checkCoordinates(lat, lon); err != nil {
+			return fmt.Errorf("invalid centre for 'withinRadius': %v", err)
+		}
+	}
+
+	if radius, ok := value[2].(float64); ok && radius < 0 {
+		return fmt.Errorf("invalid radius for 'withinRadius': %g is negative", radius)
+	}
+
+	return nil
+}
+
+func checkCoordinates(lat, lon float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %g is out of range", lat)
+	}
+
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude %g is out of range", lon)
+	}
+
+	return nil
+}
@@ -2,7 +2,10 @@ package parser
 
 import (
 	"fmt"
+	"net/netip"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/alicavdar/logix/lexer"
 )
@@ -11,32 +14,127 @@ type SingleValue interface{}
 type Value []SingleValue
 
 type Condition struct {
-	Field    string
-	Operator string
-	Value    Value
-	Negate   bool
+	Quantifier string // "any", "all" or "none" when the field is checked element by element, otherwise empty
+	Field      string // field path, or the source text of Left
+	Left       Expr   // expression computing the checked value, nil when it is just the field
+	Operator   string
+	Value      Value
+	Negate     bool
+	Compiled   interface{}    // Value prepared at parse time for operators that need it, like the *regexp.Regexp of matches or the []netip.Prefix of inCidr
+	Pos        lexer.Position // start of the field
+	End        lexer.Position // end of the last value
 }
 
 type Group struct {
-	LogicalOp string        // "and" or "or"
-	Children  []interface{} // can be either Condition or Group (for nested groups)
+	LogicalOp string         // "and" or "or"
+	Children  []interface{}  // can be either Condition or Group (for nested groups)
+	Pos       lexer.Position // start of the "group" keyword
+	End       lexer.Position // end of the last child
 }
 
 type Parser struct {
 	lexer     *lexer.Lexer
+	prevToken lexer.Token // the last consumed token
 	currToken lexer.Token
 	peekToken lexer.Token
+	peekStray bool // peekToken starts a line that is indented without a group header
+	lastRead  lexer.Token
+	lineStart lexer.TokenKind // kind of the first token on the line of lastRead
+	blocks    []bool          // for every open indentation, whether a group header opened it
+	errors    ErrorList
+	operators map[string]OperatorSyntax // custom operators declared with DeclareOperator
+}
+
+// OperatorSyntax describes how a custom operator is written in a condition.
+type OperatorSyntax struct {
+	Unary     bool // the operator takes no value, like exists
+	Negatable bool // the operator may be preceded by not
 }
 
 func NewParser(lexer *lexer.Lexer) *Parser {
 	p := &Parser{lexer: lexer}
 	p.nextToken()
+	p.nextToken()
 	return p
 }
 
+var operatorKinds = []lexer.TokenKind{
+	lexer.EQ,
+	lexer.NEQ,
+	lexer.GT,
+	lexer.GTE,
+	lexer.LT,
+	lexer.LTE,
+	lexer.CONTAINS,
+	lexer.BETWEEN,
+	lexer.IN,
+	lexer.STARTS_WITH,
+	lexer.ENDS_WITH,
+	lexer.MATCHES,
+	lexer.IEQ,
+	lexer.ICONTAINS,
+	lexer.ISTARTS_WITH,
+	lexer.IENDS_WITH,
+	lexer.IIN,
+	lexer.CONTAINS_ANY,
+	lexer.CONTAINS_ALL,
+	lexer.SUBSET_OF,
+	lexer.INTERSECTS,
+	lexer.IN_CIDR,
+	lexer.IS_PRIVATE,
+	lexer.IS_LOOPBACK,
+	lexer.WITHIN_RADIUS,
+	lexer.INSIDE_POLYGON,
+}
+
+var valueKinds = []lexer.TokenKind{
+	lexer.STRING,
+	lexer.NUMBER,
+	lexer.TRUE,
+	lexer.FALSE,
+	lexer.NIL,
+}
+
+// operandKinds are the tokens that can start a value: a literal, an
+// identifier that refers to another field or function, or a parenthesized
+// expression.
+var operandKinds = []lexer.TokenKind{
+	lexer.STRING,
+	lexer.NUMBER,
+	lexer.TRUE,
+	lexer.FALSE,
+	lexer.NIL,
+	lexer.IDENT,
+	lexer.LPAREN,
+}
+
 func (p *Parser) parseCondition() *Condition {
-	field := p.currToken.Lexeme
-	p.nextToken()
+	pos := p.currToken.Pos
+	line := pos.Line
+
+	quantifier := ""
+	if p.atQuantifier() {
+		quantifier = p.currToken.Lexeme
+		p.nextToken()
+
+		if p.currToken.Kind != lexer.IDENT {
+			p.expectError(lexer.IDENT)
+			p.skipLine(line)
+			return nil
+		}
+	}
+
+	left, ok := p.parseExpr()
+	if !ok {
+		p.skipLine(line)
+		return nil
+	}
+
+	field := left.String()
+	if ref, isRef := left.(*FieldRef); isRef {
+		field = ref.Path
+		left = nil
+	}
 
 	negate := false
 	if p.currToken.Kind == lexer.NOT {
@@ -44,114 +142,361 @@ func (p *Parser) parseCondition() *Condition {
 		p.nextToken()
 	}
 
+	// Unknown identifiers are accepted as operators and reported by the evaluator
+	if !p.currTokenIs(operatorKinds...) && p.currToken.Kind != lexer.IDENT {
+		p.expectError(operatorKinds...)
+		p.skipLine(line)
+		return nil
+	}
+
 	operator := p.currToken.Lexeme
-	if negate && !allowedNegateSuffix(operator) {
-		panic(fmt.Sprintf("Negation is not supported for operator: %s", operator))
+	syntax := p.operatorSyntax(operator)
+	if negate && !syntax.Negatable {
+		p.errorf(p.currToken, "negation is not supported for operator '%s'", operator)
+		p.skipLine(line)
+		return nil
 	}
 	p.nextToken()
 
+	valueToken := p.currToken
 	var value Value
-	if operator == "between" {
-		value = p.parseRange()
+	if syntax.Unary {
+		ok = true
+	} else if p.currToken.Pos.Line != line || p.currTokenIs(lexer.DEDENT, lexer.EOF) {
+		// The value is missing, and what follows belongs to the next statement
+		p.expectError(valueKinds...)
+		ok = false
+	} else if operator == "between" {
+		value, ok = p.parseRange()
 	} else if p.currToken.Kind == lexer.LSQUARE {
-		value = p.parseArray()
+		value, ok = p.parseArray()
 	} else {
-		value = append(value, parseValue(p.currToken))
+		var single SingleValue
+		single, ok = p.parseValue()
+		value = append(value, single)
+	}
+
+	if !ok || !p.endStatement(line) {
+		p.skipLine(line)
+		return nil
+	}
+
+	compiled, ok := p.compileValue(operator, value, valueToken)
+	if !ok {
+		return nil
+	}
+
+	return &Condition{
+		Quantifier: quantifier,
+		Field:      field,
+		Left:       left,
+		Operator:   operator,
+		Value:      value,
+		Negate:     negate,
+		Compiled:   compiled,
+		Pos:        pos,
+		End:        p.prevToken.End,
 	}
-	return &Condition{Field: field, Operator: operator, Value: value, Negate: negate}
 }
 
 func (p *Parser) parseGroup() *Group {
+	pos := p.currToken.Pos
+	line := pos.Line
 	p.nextToken()
 
-	if p.currToken.Kind != lexer.AND && p.currToken.Kind != lexer.OR {
-		panic(fmt.Sprintf("Expected logical operator 'and' or 'or', got: %s", p.currToken.Lexeme))
+	var group *Group
+	if p.currTokenIs(lexer.AND, lexer.OR) {
+		group = &Group{LogicalOp: p.currToken.Lexeme, Pos: pos}
+		p.nextToken()
+
+		if !p.endStatement(line) {
+			group = nil
+		}
+	} else {
+		p.expectError(lexer.AND, lexer.OR)
 	}
 
-	group := &Group{LogicalOp: p.currToken.Lexeme}
-	p.nextToken()
+	// The body is parsed even when the header is broken so that errors inside
+	// it are reported as well
+	p.skipLine(line)
 
-	for p.currToken.Kind != lexer.DEDENT && p.currToken.Kind != lexer.EOF {
-		switch p.currToken.Kind {
-		case lexer.GROUP:
-			group.Children = append(group.Children, p.parseGroup())
-		case lexer.IDENT:
-			group.Children = append(group.Children, p.parseCondition())
+	for !p.currTokenIs(lexer.DEDENT, lexer.EOF) {
+		child := p.parseStatement()
+		if group != nil && child != nil {
+			group.Children = append(group.Children, child)
 		}
+	}
+
+	if group != nil {
+		group.End = p.prevToken.End
+	}
 
+	if p.currToken.Kind == lexer.DEDENT {
 		p.nextToken()
 	}
 
+	if group == nil {
+		return nil
+	}
+
 	return group
 }
 
-func (p *Parser) parseRange() Value {
+// compileValue prepares the value of operators that would otherwise redo the
+// same work on every evaluation, so that mistakes in it are reported as parse
+// errors. token is the first token of the value.
+func (p *Parser) compileValue(operator string, value Value, token lexer.Token) (interface{}, bool) {
+	if operator != "insidePolygon" {
+		for _, v := range value {
+			if _, nested := v.(Value); nested {
+				p.errorf(token, "nested arrays are only supported by 'insidePolygon'")
+				return nil, false
+			}
+		}
+	}
+
+	switch operator {
+	case "matches":
+		pattern, isString := "", false
+		if len(value) == 1 && token.Kind == lexer.STRING {
+			pattern, isString = value[0].(string)
+		}
+
+		if !isString {
+			p.errorf(token, "operator 'matches' expects a string pattern, got %s", describeToken(token))
+			return nil, false
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			p.errorf(token, "invalid pattern for 'matches': %v", err)
+			return nil, false
+		}
+
+		return re, true
+	case "inCidr":
+		// Prefixes written as literals are parsed once here, the evaluator
+		// parses them itself when some come from the context
+		prefixes := make([]netip.Prefix, 0, len(value))
+		for _, v := range value {
+			literal, isString := v.(string)
+			if !isString {
+				return nil, true
+			}
+
+			prefix, err := netip.ParsePrefix(literal)
+			if err != nil {
+				p.errorf(token, "invalid prefix for 'inCidr': %v", err)
+				return nil, false
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+		}
+
+		return prefixes, true
+	case "withinRadius":
+		if err := checkRadius(value); err != nil {
+			p.errorf(token, "%v", err)
+			return nil, false
+		}
+
+		return nil, true
+	case "insidePolygon":
+		if token.Kind != lexer.LSQUARE {
+			p.errorf(token, "operator 'insidePolygon' expects a list of [latitude, longitude] corners, got %s", describeToken(token))
+			return nil, false
+		}
+
+		polygon, err := compilePolygon(value)
+		if err != nil {
+			p.errorf(token, "invalid polygon for 'insidePolygon': %v", err)
+			return nil, false
+		}
+
+		return polygon, true
+	default:
+		return nil, true
+	}
+}
+
+func (p *Parser) parseRange() (Value, bool) {
 	var value Value
-	value = append(value, parseValue(p.currToken))
-	p.nextToken()
+
+	low, ok := p.parseValue()
+	if !ok {
+		return nil, false
+	}
+	value = append(value, low)
 
 	if p.currToken.Kind != lexer.AND {
-		panic("Expected 'and' in range condition")
+		p.expectError(lexer.AND)
+		return nil, false
 	}
 	p.nextToken()
 
-	value = append(value, parseValue(p.currToken))
-	return value
+	high, ok := p.parseValue()
+	if !ok {
+		return nil, false
+	}
+	value = append(value, high)
+
+	return value, true
 }
 
 func (p *Parser) nextToken() {
-	p.currToken = p.lexer.Next()
-
-	for p.currToken.Kind == lexer.INDENT {
-		p.currToken = p.lexer.Next()
+	p.prevToken = p.currToken
+	p.currToken = p.peekToken
+	if p.peekStray {
+		p.errorf(p.currToken, "unexpected indentation")
 	}
+
+	p.peekToken, p.peekStray = p.readToken()
 }
 
-func (p *Parser) ParseNext() interface{} {
-	if p.currToken.Kind == lexer.EOF {
-		return nil
-	}
+// readToken returns the next token from the lexer, without INDENT tokens.
+// Indentation is only expected after a group header. Other indented lines are
+// reported by nextToken and parsed as if they were not indented, so the DEDENT
+// that closes them is dropped and does not end an enclosing group. The second
+// result reports whether the token starts such a line.
+func (p *Parser) readToken() (lexer.Token, bool) {
+	stray := false
+	for {
+		token := p.lexer.Next()
+
+		switch token.Kind {
+		case lexer.INDENT:
+			expected := p.lineStart == lexer.GROUP
+			p.blocks = append(p.blocks, expected)
+			stray = stray || !expected
+			continue
+		case lexer.DEDENT:
+			if n := len(p.blocks); n > 0 {
+				expected := p.blocks[n-1]
+				p.blocks = p.blocks[:n-1]
+				if !expected {
+					continue
+				}
+			}
+
+			return token, stray
+		}
+
+		if token.Pos.Line != p.lastRead.End.Line {
+			p.lineStart = token.Kind
+		}
+		p.lastRead = token
 
-	var result interface{}
+		return token, stray
+	}
+}
 
+// parseStatement parses a single condition or group. It returns nil if the
+// statement is malformed, in which case the error has been recorded and the
+// parser has moved on to the next statement.
+func (p *Parser) parseStatement() interface{} {
 	switch p.currToken.Kind {
 	case lexer.GROUP:
-		result = p.parseGroup()
-	case lexer.IDENT:
-		result = p.parseCondition()
+		if group := p.parseGroup(); group != nil {
+			return group
+		}
+	case lexer.IDENT, lexer.NUMBER, lexer.DURATION, lexer.DATETIME, lexer.STRING, lexer.LPAREN, lexer.MINUS:
+		if condition := p.parseCondition(); condition != nil {
+			return condition
+		}
 	default:
-		panic(fmt.Sprintf("Unexpected token: '%s' of kind '%s'", p.currToken.Lexeme, p.currToken.Kind))
+		p.expectError(lexer.GROUP, lexer.IDENT, lexer.NUMBER, lexer.DURATION, lexer.DATETIME, lexer.STRING, lexer.LPAREN, lexer.MINUS)
+		line := p.currToken.Pos.Line
+		p.nextToken()
+		p.skipLine(line)
 	}
 
-	p.nextToken()
+	return nil
+}
 
-	return result
+// ParseNext returns the next top-level condition or group, or nil at the end
+// of the input. Malformed statements are skipped and recorded in Errors.
+func (p *Parser) ParseNext() interface{} {
+	for p.currToken.Kind != lexer.EOF {
+		if node := p.parseStatement(); node != nil {
+			return node
+		}
+	}
+
+	return nil
 }
 
-func (p *Parser) parseArray() Value {
+// Parse consumes the remaining input and returns every top-level condition and
+// group in source order. If any statement is malformed, the returned error is
+// an ErrorList holding every problem found in the input.
+func (p *Parser) Parse() ([]interface{}, error) {
+	var nodes []interface{}
+
+	for {
+		node := p.ParseNext()
+		if node == nil {
+			break
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, p.errors.Err()
+}
+
+// Errors returns the errors recorded so far.
+func (p *Parser) Errors() ErrorList {
+	return p.errors
+}
+
+func (p *Parser) parseArray() (Value, bool) {
 	p.nextToken()
 
-	var arrayValues Value
+	arrayValues := Value{}
 	for p.currToken.Kind != lexer.RSQUARE {
-		arrayValues = append(arrayValues, parseValue(p.currToken))
-
-		p.nextToken()
+		var value SingleValue
+		var ok bool
+		if p.currToken.Kind == lexer.LSQUARE {
+			// Nested arrays hold coordinates, like the corners of a polygon
+			value, ok = p.parseArray()
+		} else {
+			value, ok = p.parseValue()
+		}
+		if !ok {
+			return nil, false
+		}
+		arrayValues = append(arrayValues, value)
 
 		if p.currToken.Kind == lexer.COMMA {
 			p.nextToken()
+		} else if p.currToken.Kind != lexer.RSQUARE {
+			p.expectError(lexer.COMMA, lexer.RSQUARE)
+			return nil, false
 		}
 	}
+	p.nextToken()
+
+	return arrayValues, true
+}
+
+// parseValue parses the value of a condition. Literals are stored as they
+// are, anything else as the Expr that computes the value.
+func (p *Parser) parseValue() (SingleValue, bool) {
+	expr, ok := p.parseExpr()
+	if !ok {
+		return nil, false
+	}
 
-	if p.currToken.Kind != lexer.RSQUARE {
-		panic("Expected ']' to close array")
+	if literal, isLiteral := expr.(*Literal); isLiteral {
+		return literal.Value, true
 	}
 
-	return arrayValues
+	return expr, true
 }
 
-func parseValue(token lexer.Token) SingleValue {
+func (p *Parser) parseLiteral() (SingleValue, bool) {
 	var value SingleValue
 
+	token := p.currToken
 	switch token.Kind {
 	case lexer.TRUE:
 		value = true
@@ -160,22 +505,161 @@ func parseValue(token lexer.Token) SingleValue {
 	case lexer.NIL:
 		value = nil
 	case lexer.NUMBER:
-		number, err := strconv.ParseFloat(token.Lexeme, 64)
+		number, err := strconv.ParseFloat(strings.ReplaceAll(token.Lexeme, "_", ""), 64)
 		if err != nil {
-			panic(fmt.Sprintf("Invalid number literal: %v", err))
+			p.errorf(token, "invalid number literal '%s'", token.Lexeme)
+			return nil, false
 		}
 
 		value = number
 	case lexer.STRING:
 		value = token.Lexeme
+	case lexer.DATETIME:
+		datetime, err := ParseDateTime(token.Lexeme)
+		if err != nil {
+			p.errorf(token, "invalid date/time literal '%s'", token.Lexeme)
+			return nil, false
+		}
+
+		value = datetime
+	case lexer.DURATION:
+		duration, err := parseDuration(token.Lexeme)
+		if err != nil {
+			p.errorf(token, "invalid duration literal '%s'", token.Lexeme)
+			return nil, false
+		}
+
+		value = duration
+	default:
+		p.expectError(valueKinds...)
+		return nil, false
 	}
 
-	return value
+	p.nextToken()
+	return value, true
+}
+
+// endStatement reports an error if anything other than the start of the next
+// statement follows on the line of the statement that was just parsed.
+func (p *Parser) endStatement(line int) bool {
+	if p.currTokenIs(lexer.DEDENT, lexer.EOF) || p.currToken.Pos.Line != line {
+		return true
+	}
+
+	p.errorf(p.currToken, "unexpected %s at end of statement", describeToken(p.currToken))
+	return false
+}
+
+// skipLine moves past the remaining tokens on the given line so that parsing
+// can resume at the next statement.
+func (p *Parser) skipLine(line int) {
+	for p.currToken.Pos.Line == line && !p.currTokenIs(lexer.DEDENT, lexer.EOF) {
+		p.nextToken()
+	}
+}
+
+func (p *Parser) currTokenIs(kinds ...lexer.TokenKind) bool {
+	for _, kind := range kinds {
+		if p.currToken.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Parser) errorf(token lexer.Token, format string, args ...interface{}) {
+	p.errors = append(p.errors, &ParseError{
+		Pos:     token.Pos,
+		Token:   token,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (p *Parser) expectError(expected ...lexer.TokenKind) {
+	token := p.currToken
+
+	var message string
+	if token.Kind == lexer.ILLEGAL {
+		message = illegalMessage(token)
+	} else {
+		message = fmt.Sprintf("expected %s, got %s", joinKinds(expected), describeToken(token))
+	}
+
+	p.errors = append(p.errors, &ParseError{
+		Pos:      token.Pos,
+		Token:    token,
+		Expected: expected,
+		Message:  message,
+	})
+}
+
+// DeclareOperator tells the parser how a custom operator is written, so that
+// it can be negated or used without a value. Identifiers that are not declared
+// are still accepted as operators that take a value and cannot be negated.
+func (p *Parser) DeclareOperator(name string, syntax OperatorSyntax) {
+	if p.operators == nil {
+		p.operators = map[string]OperatorSyntax{}
+	}
+
+	p.operators[name] = syntax
+}
+
+// atQuantifier reports whether the current token is any, all or none used as
+// a quantifier. They are only quantifiers when an operand follows on the same
+// line, so `all eq 1` and `any exists` still read them as field names.
+func (p *Parser) atQuantifier() bool {
+	switch p.currToken.Lexeme {
+	case "any", "all", "none":
+	default:
+		return false
+	}
+
+	if p.currToken.Kind != lexer.IDENT || p.peekToken.Pos.Line != p.currToken.Pos.Line {
+		return false
+	}
+
+	for _, kind := range operandKinds {
+		if p.peekToken.Kind != kind {
+			continue
+		}
+		if kind != lexer.IDENT {
+			return true
+		}
+		// An operator after the word makes the word a field
+		_, declared := p.operators[p.peekToken.Lexeme]
+		return !declared && !lexer.IsKeyword(p.peekToken.Lexeme)
+	}
+
+	return false
+}
+
+func (p *Parser) operatorSyntax(op string) OperatorSyntax {
+	if syntax, ok := p.operators[op]; ok {
+		return syntax
+	}
+
+	return OperatorSyntax{Unary: isUnaryOperator(op), Negatable: allowedNegateSuffix(op)}
 }
 
 func allowedNegateSuffix(op string) bool {
 	switch op {
-	case "in", "contains", "between", "startsWith", "endsWith":
+	case "in", "contains", "between", "startsWith", "endsWith", "matches",
+		"iin", "icontains", "istartsWith", "iendsWith", "exists",
+		"containsAny", "containsAll", "subsetOf", "intersects", "isEmpty",
+		"before", "after", "inCidr", "isPrivate", "isLoopback",
+		"withinRadius", "insidePolygon":
+		return true
+	default:
+		return false
+	}
+}
+
+// isUnaryOperator reports whether the operator only looks at the field and
+// takes no value.
+func isUnaryOperator(op string) bool {
+	switch op {
+	case "exists", "isEmpty", "isPrivate", "isLoopback":
 		return true
 	default:
 		return false
@@ -1,7 +1,11 @@
 package parser
 
 import (
+	"net/netip"
+	"regexp"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/alicavdar/logix/lexer"
 )
@@ -30,6 +34,13 @@ field14 in [1, 2, 3]
 field14 in ["lorem", 1, 2]
 field15 eq true
 field16 eq false
+field17 ieq "Hello"
+field18 not icontains "hello"
+field19 istartsWith "hel"
+field20 iendsWith "LO"
+field21 not iin ["a", "B"]
+field22 exists
+field23 not exists
 `
 	p := newTestParser(input)
 
@@ -50,6 +61,13 @@ field16 eq false
 	assertCondition(t, p.ParseNext(), "field14", "in", Value{"lorem", 1.0, 2.0}, false)
 	assertCondition(t, p.ParseNext(), "field15", "eq", Value{true}, false)
 	assertCondition(t, p.ParseNext(), "field16", "eq", Value{false}, false)
+	assertCondition(t, p.ParseNext(), "field17", "ieq", Value{"Hello"}, false)
+	assertCondition(t, p.ParseNext(), "field18", "icontains", Value{"hello"}, true)
+	assertCondition(t, p.ParseNext(), "field19", "istartsWith", Value{"hel"}, false)
+	assertCondition(t, p.ParseNext(), "field20", "iendsWith", Value{"LO"}, false)
+	assertCondition(t, p.ParseNext(), "field21", "iin", Value{"a", "B"}, true)
+	assertCondition(t, p.ParseNext(), "field22", "exists", Value{}, false)
+	assertCondition(t, p.ParseNext(), "field23", "exists", Value{}, true)
 }
 
 func TestParseGroup(t *testing.T) {
@@ -119,6 +137,398 @@ age between 10 and 30
 	assertCondition(t, result, "age", "between", Value{10.0, 30.0}, false)
 }
 
+func TestQuantifiers(t *testing.T) {
+	input := `
+items[*].sku eq "X"
+any items[*].price gt 100
+all orders[*].items[*].price gt 0
+none tags contains "deleted"
+`
+	p := newTestParser(input)
+
+	expected := []struct {
+		quantifier string
+		field      string
+	}{
+		{"", "items[*].sku"},
+		{"any", "items[*].price"},
+		{"all", "orders[*].items[*].price"},
+		{"none", "tags"},
+	}
+
+	for _, exp := range expected {
+		condition := assertConditionNode(t, p.ParseNext())
+		if condition.Quantifier != exp.quantifier || condition.Field != exp.field {
+			t.Errorf("Expected %s %s, got %s %s", exp.quantifier, exp.field, condition.Quantifier, condition.Field)
+		}
+	}
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestFunctionCalls(t *testing.T) {
+	input := `
+len(items) gte 3
+len(order.lines[*].sku) eq 0
+comment not isEmpty
+`
+	p := newTestParser(input)
+
+	condition := assertConditionNode(t, p.ParseNext())
+	call, ok := condition.Left.(*CallExpr)
+	if !ok {
+		t.Fatalf("Expected *CallExpr, got %T", condition.Left)
+	}
+	if call.Func != "len" || len(call.Args) != 1 || call.Args[0].String() != "items" {
+		t.Errorf("Expected len(items), got %s", call)
+	}
+	if condition.Field != "len(items)" || call.End.Column != 11 {
+		t.Errorf("Expected field len(items) ending at column 11, got %s ending at column %d", condition.Field, call.End.Column)
+	}
+	assertCondition(t, condition, "len(items)", "gte", Value{3.0}, false)
+
+	assertCondition(t, p.ParseNext(), "len(order.lines[*].sku)", "eq", Value{0.0}, false)
+
+	condition = assertConditionNode(t, p.ParseNext())
+	if condition.Left != nil {
+		t.Errorf("Expected no expression for a plain field, got %s", condition.Left)
+	}
+	assertCondition(t, condition, "comment", "isEmpty", nil, true)
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestFieldReferences(t *testing.T) {
+	input := `
+discount_price lt list_price
+age between limits.min and limits.max
+role in allowed_roles
+`
+	p := newTestParser(input)
+
+	condition := assertConditionNode(t, p.ParseNext())
+	ref, ok := condition.Value[0].(*FieldRef)
+	if !ok {
+		t.Fatalf("Expected *FieldRef, got %T", condition.Value[0])
+	}
+	if ref.Path != "list_price" || ref.Pos.Column != 19 {
+		t.Errorf("Expected list_price at column 19, got %s at column %d", ref.Path, ref.Pos.Column)
+	}
+
+	expected := []string{
+		"age between limits.min and limits.max",
+		"role in [allowed_roles]",
+	}
+	for _, exp := range expected {
+		condition := assertConditionNode(t, p.ParseNext())
+		if condition.String() != exp {
+			t.Errorf("Expected %s, got %s", exp, condition.String())
+		}
+	}
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	input := `
+price * quantity gt 500
+total - refunded gte 100
+a + b * c eq 7
+(a + b) * c eq 9
+a - (b - c) eq 1
+a - b - c eq 1
+-(price - cost) / price lt 0.2
+price % 3 eq - 2
+total gte refunded * 2 + 10
+`
+	expected := []string{
+		`price * quantity gt 500`,
+		`total - refunded gte 100`,
+		`a + b * c eq 7`,
+		`(a + b) * c eq 9`,
+		`a - (b - c) eq 1`,
+		`a - b - c eq 1`,
+		`-(price - cost) / price lt 0.2`,
+		`price % 3 eq -2`,
+		`total gte refunded * 2 + 10`,
+	}
+
+	p := newTestParser(input)
+	for _, exp := range expected {
+		condition := assertConditionNode(t, p.ParseNext())
+		if condition.String() != exp {
+			t.Errorf("Expected %s, got %s", exp, condition.String())
+		}
+	}
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+
+	p = newTestParser(`a + b * c eq 7`)
+	condition := assertConditionNode(t, p.ParseNext())
+	sum, ok := condition.Left.(*BinaryExpr)
+	if !ok || sum.Op != "+" {
+		t.Fatalf("Expected a + at the root, got %v", condition.Left)
+	}
+	if product, ok := sum.Right.(*BinaryExpr); !ok || product.Op != "*" {
+		t.Errorf("Expected b * c on the right, got %v", sum.Right)
+	}
+	if sum.End.Column != 10 {
+		t.Errorf("Expected the expression to end at column 10, got %d", sum.End.Column)
+	}
+}
+
+func TestDeclareOperator(t *testing.T) {
+	input := `
+sku matchesSku "A-*"
+sku not matchesSku "B-*"
+user isAdmin
+user not isAdmin
+`
+	p := newTestParser(input)
+	p.DeclareOperator("matchesSku", OperatorSyntax{Negatable: true})
+	p.DeclareOperator("isAdmin", OperatorSyntax{Unary: true, Negatable: true})
+
+	assertCondition(t, p.ParseNext(), "sku", "matchesSku", Value{"A-*"}, false)
+	assertCondition(t, p.ParseNext(), "sku", "matchesSku", Value{"B-*"}, true)
+	assertCondition(t, p.ParseNext(), "user", "isAdmin", nil, false)
+	assertCondition(t, p.ParseNext(), "user", "isAdmin", nil, true)
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+
+	// Without the declaration, isAdmin needs a value and cannot be negated
+	p = newTestParser("user not isAdmin\nuser isAdmin\n")
+	if _, err := p.Parse(); err == nil || len(p.Errors()) != 2 {
+		t.Errorf("Expected 2 errors, got %v", err)
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	input := `
+temperature gt -5
+ratio lt 0.25
+population gte 1e6
+budget lte 1_000_000
+delta between -1.5 and +2.5e-1
+codes in [-1, 0.5, 2E3]
+`
+	p := newTestParser(input)
+
+	assertCondition(t, p.ParseNext(), "temperature", "gt", Value{-5.0}, false)
+	assertCondition(t, p.ParseNext(), "ratio", "lt", Value{0.25}, false)
+	assertCondition(t, p.ParseNext(), "population", "gte", Value{1e6}, false)
+	assertCondition(t, p.ParseNext(), "budget", "lte", Value{1000000.0}, false)
+	assertCondition(t, p.ParseNext(), "delta", "between", Value{-1.5, 0.25}, false)
+	assertCondition(t, p.ParseNext(), "codes", "in", Value{-1.0, 0.5, 2000.0}, false)
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestTemporalLiterals(t *testing.T) {
+	input := `
+created_at after 2024-01-31
+created_at before 2024-01-31T09:30:00Z
+created_at between 2024-01-01T00:00 and 2024-02-01
+created_at after now() - 7d
+created_at gte 2024-01-31T09:30:00.250+02:00
+`
+	p := newTestParser(input)
+
+	assertCondition(t, p.ParseNext(), "created_at", "after", Value{time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)}, false)
+	assertCondition(t, p.ParseNext(), "created_at", "before", Value{time.Date(2024, 1, 31, 9, 30, 0, 0, time.UTC)}, false)
+	assertCondition(t, p.ParseNext(), "created_at", "between", Value{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}, false)
+
+	condition := assertConditionNode(t, p.ParseNext())
+	if condition.String() != "created_at after now() - 7d" {
+		t.Errorf("Expected created_at after now() - 7d, got %s", condition.String())
+	}
+
+	condition = assertConditionNode(t, p.ParseNext())
+	expected := time.Date(2024, 1, 31, 7, 30, 0, 250_000_000, time.UTC)
+	if value, ok := condition.Value[0].(time.Time); !ok || !value.Equal(expected) {
+		t.Errorf("Expected %v, got %v", expected, condition.Value[0])
+	}
+	if condition.String() != "created_at gte 2024-01-31T09:30:00.25+02:00" {
+		t.Errorf("Expected the offset to be kept, got %s", condition.String())
+	}
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestDurationLiterals(t *testing.T) {
+	input := `
+session_length gt 45m
+timeout in [250ms, 1.5s, 1h30m, -2d12h]
+elapsed between 0s and 7d
+latency in [0.0001s, -1.000001ms]
+uptime lt -(1h + 30m) * 2
+`
+	p := newTestParser(input)
+
+	assertCondition(t, p.ParseNext(), "session_length", "gt", Value{45 * time.Minute}, false)
+	condition := assertCondition(t, p.ParseNext(), "timeout", "in", Value{250 * time.Millisecond, 1500 * time.Millisecond, 90 * time.Minute, -60 * time.Hour}, false)
+	if condition.String() != "timeout in [250ms, 1500ms, 90m, -60h]" {
+		t.Errorf("Expected timeout in [250ms, 1500ms, 90m, -60h], got %s", condition.String())
+	}
+	assertCondition(t, p.ParseNext(), "elapsed", "between", Value{time.Duration(0), 7 * 24 * time.Hour}, false)
+
+	// Durations below a millisecond read back as the same value
+	condition = assertCondition(t, p.ParseNext(), "latency", "in", Value{100 * time.Microsecond, -1000001 * time.Nanosecond}, false)
+	if condition.String() != "latency in [0.1ms, -1.000001ms]" {
+		t.Errorf("Expected latency in [0.1ms, -1.000001ms], got %s", condition.String())
+	}
+	reparsed := assertConditionNode(t, newTestParser(condition.String()).ParseNext())
+	if !slicesEqual(reparsed.Value, condition.Value) {
+		t.Errorf("Expected %v to read back, got %v", condition.Value, reparsed.Value)
+	}
+
+	condition = assertConditionNode(t, p.ParseNext())
+	if condition.String() != "uptime lt -(1h + 30m) * 2" {
+		t.Errorf("Expected uptime lt -(1h + 30m) * 2, got %s", condition.String())
+	}
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestMatchesCompilesPattern(t *testing.T) {
+	p := newTestParser(`
+sku matches "^SKU-[0-9]{4}$"
+code not matches "^SKU-[0-9]{4}$"
+`)
+
+	for _, condition := range []*Condition{
+		assertCondition(t, p.ParseNext(), "sku", "matches", Value{"^SKU-[0-9]{4}$"}, false),
+		assertCondition(t, p.ParseNext(), "code", "matches", Value{"^SKU-[0-9]{4}$"}, true),
+	} {
+		re, ok := condition.Compiled.(*regexp.Regexp)
+		if !ok {
+			t.Fatalf("Expected *regexp.Regexp, got %T", condition.Compiled)
+		}
+		if !re.MatchString("SKU-1234") {
+			t.Errorf("Expected the compiled pattern to match")
+		}
+	}
+}
+
+func TestInCidrCompilesPrefixes(t *testing.T) {
+	p := newTestParser(`
+client_ip inCidr ["10.0.0.0/8", "2001:db8::/32"]
+client_ip not inCidr "192.168.1.7/16"
+client_ip inCidr office_ranges
+client_ip not isPrivate
+client_ip isLoopback
+`)
+
+	condition := assertCondition(t, p.ParseNext(), "client_ip", "inCidr", Value{"10.0.0.0/8", "2001:db8::/32"}, false)
+	expected := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	if prefixes, ok := condition.Compiled.([]netip.Prefix); !ok || !slices.Equal(prefixes, expected) {
+		t.Errorf("Expected %v, got %v", expected, condition.Compiled)
+	}
+
+	condition = assertCondition(t, p.ParseNext(), "client_ip", "inCidr", Value{"192.168.1.7/16"}, true)
+	if prefixes, ok := condition.Compiled.([]netip.Prefix); !ok || prefixes[0].String() != "192.168.0.0/16" {
+		t.Errorf("Expected the prefix to be masked, got %v", condition.Compiled)
+	}
+	if condition.String() != `client_ip not inCidr ["192.168.1.7/16"]` {
+		t.Errorf(`Expected client_ip not inCidr ["192.168.1.7/16"], got %s`, condition.String())
+	}
+
+	// Prefixes from the context are parsed by the evaluator
+	condition = assertConditionNode(t, p.ParseNext())
+	if condition.Compiled != nil {
+		t.Errorf("Expected no compiled prefixes, got %v", condition.Compiled)
+	}
+
+	assertCondition(t, p.ParseNext(), "client_ip", "isPrivate", nil, true)
+	assertCondition(t, p.ParseNext(), "client_ip", "isLoopback", nil, false)
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestGeoOperators(t *testing.T) {
+	p := newTestParser(`
+location withinRadius [52.52, 13.405, 5]
+location not withinRadius [warehouse.lat, warehouse.lon, 2.5]
+location insidePolygon [[52.5, 13.3], [52.6, 13.4], [52.45, 13.55]]
+`)
+
+	assertCondition(t, p.ParseNext(), "location", "withinRadius", Value{52.52, 13.405, 5.0}, false)
+	condition := assertConditionNode(t, p.ParseNext())
+	if condition.String() != "location not withinRadius [warehouse.lat, warehouse.lon, 2.5]" {
+		t.Errorf("Expected location not withinRadius [warehouse.lat, warehouse.lon, 2.5], got %s", condition.String())
+	}
+
+	condition = assertConditionNode(t, p.ParseNext())
+	polygon, ok := condition.Compiled.(*Polygon)
+	if !ok {
+		t.Fatalf("Expected *Polygon, got %T", condition.Compiled)
+	}
+	if len(polygon.Vertices) != 3 || polygon.Vertices[2] != [2]float64{52.45, 13.55} {
+		t.Errorf("Expected 3 vertices, got %v", polygon.Vertices)
+	}
+	if polygon.MinLat != 52.45 || polygon.MaxLat != 52.6 || polygon.MinLon != 13.3 || polygon.MaxLon != 13.55 {
+		t.Errorf("Unexpected bounding box %v", polygon)
+	}
+	if condition.String() != "location insidePolygon [[52.5, 13.3], [52.6, 13.4], [52.45, 13.55]]" {
+		t.Errorf("Expected the corners to be kept, got %s", condition.String())
+	}
+
+	if err := p.Errors().Err(); err != nil {
+		t.Errorf("Did not expect an error but got: %v", err)
+	}
+}
+
+func TestConditionString(t *testing.T) {
+	input := `
+title not contains "say \"hi\""
+age between 10 and 20.5
+tags in ["a", nil, true, 1]
+status eq nil
+roles containsAll ["admin"]
+`
+	expected := []string{
+		`title not contains "say \"hi\""`,
+		`age between 10 and 20.5`,
+		`tags in ["a", nil, true, 1]`,
+		`status eq nil`,
+		`roles containsAll ["admin"]`,
+	}
+
+	p := newTestParser(input)
+	for _, exp := range expected {
+		condition := assertConditionNode(t, p.ParseNext())
+		if condition.String() != exp {
+			t.Errorf("Expected %s, got %s", exp, condition.String())
+		}
+	}
+}
+
+func assertConditionNode(t *testing.T, result interface{}) *Condition {
+	condition, ok := result.(*Condition)
+	if !ok {
+		t.Fatalf("Expected *Condition, got %T", result)
+	}
+
+	return condition
+}
+
 func slicesEqual(a, b Value) bool {
 	if len(a) != len(b) {
 		return false
@@ -138,6 +548,14 @@ func slicesEqual(a, b Value) bool {
 			if bv, ok := b[i].(bool); !ok || v != bv {
 				return false
 			}
+		case time.Time:
+			if bv, ok := b[i].(time.Time); !ok || !v.Equal(bv) {
+				return false
+			}
+		case time.Duration:
+			if bv, ok := b[i].(time.Duration); !ok || v != bv {
+				return false
+			}
 		case nil:
 			if b[i] != nil {
 				return false
@@ -188,3 +606,267 @@ func assertGroup(t *testing.T, result interface{}, expectedLogicalOp string, exp
 
 	return group
 }
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "Missing logical operator",
+			input:    "group xor\n    field1 eq 10\n",
+			expected: []string{"line 1, column 7: expected one of AND, OR, got IDENT 'xor'"},
+		},
+		{
+			name:     "Indented last line",
+			input:    "a eq 1\n  b eq 2",
+			expected: []string{"line 2, column 3: unexpected indentation"},
+		},
+		{
+			name:     "Indented line inside a group",
+			input:    "group and\n  a eq 5\n    b eq 2\n  c eq 1",
+			expected: []string{"line 3, column 5: unexpected indentation"},
+		},
+		{
+			name:     "Unsupported negation",
+			input:    "field1 not eq 10",
+			expected: []string{"line 1, column 12: negation is not supported for operator 'eq'"},
+		},
+		{
+			name:     "Missing value",
+			input:    "field1 eq\nfield2 eq 10",
+			expected: []string{"line 2, column 1: expected one of STRING, NUMBER, TRUE, FALSE, NIL, got IDENT 'field2'"},
+		},
+		{
+			name:     "Missing 'and' in range",
+			input:    "age between 10 or 20",
+			expected: []string{"line 1, column 16: expected AND, got OR 'or'"},
+		},
+		{
+			name:     "Invalid value",
+			input:    "field1 eq )",
+			expected: []string{"line 1, column 11: expected one of STRING, NUMBER, TRUE, FALSE, NIL, IDENT, LPAREN, got RPAREN ')'"},
+		},
+		{
+			name:     "Unclosed function call",
+			input:    "len(items gte 3",
+			expected: []string{"line 1, column 11: expected one of COMMA, RPAREN, got GTE 'gte'"},
+		},
+		{
+			name:     "Unclosed parenthesis",
+			input:    "(price - cost gt 10",
+			expected: []string{"line 1, column 15: expected RPAREN, got GT 'gt'"},
+		},
+		{
+			name:     "Missing operand",
+			input:    "price * gt 10",
+			expected: []string{"line 1, column 9: expected one of STRING, NUMBER, TRUE, FALSE, NIL, IDENT, LPAREN, got GT 'gt'"},
+		},
+		{
+			name:     "Unclosed array",
+			input:    "field1 in [1, 2",
+			expected: []string{"line 1, column 16: expected one of COMMA, RSQUARE, got EOF"},
+		},
+		{
+			name:     "Missing comma in array",
+			input:    "field1 in [1 2]",
+			expected: []string{"line 1, column 14: expected one of COMMA, RSQUARE, got NUMBER '2'"},
+		},
+		{
+			name:     "Trailing token",
+			input:    "field1 eq 10 20",
+			expected: []string{"line 1, column 14: unexpected NUMBER '20' at end of statement"},
+		},
+		{
+			name:     "Malformed number",
+			input:    "field1 gt 1__000",
+			expected: []string{"line 1, column 11: malformed number '1__000'"},
+		},
+		{
+			name:     "Number out of range",
+			input:    "field1 gt 1e400",
+			expected: []string{"line 1, column 11: invalid number literal '1e400'"},
+		},
+		{
+			name:     "Invalid date",
+			input:    "created_at after 2024-02-30",
+			expected: []string{"line 1, column 18: invalid date/time literal '2024-02-30'"},
+		},
+		{
+			name:     "Duration out of range",
+			input:    "uptime gt 200000d",
+			expected: []string{"line 1, column 11: invalid duration literal '200000d'"},
+		},
+		{
+			name:     "Invalid network prefix",
+			input:    `client_ip inCidr ["10.0.0.0/8", "10.0.0.0/33"]`,
+			expected: []string{`line 1, column 18: invalid prefix for 'inCidr': netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`},
+		},
+		{
+			name:     "Radius without a centre",
+			input:    `location withinRadius [5]`,
+			expected: []string{"line 1, column 23: operator 'withinRadius' expects 3 values [latitude, longitude, radius in km], got 1"},
+		},
+		{
+			name:     "Latitude out of range",
+			input:    `location withinRadius [91, 13.4, 5]`,
+			expected: []string{"line 1, column 23: invalid centre for 'withinRadius': latitude 91 is out of range"},
+		},
+		{
+			name:     "Polygon with two corners",
+			input:    `location insidePolygon [[52.5, 13.3], [52.6, 13.4]]`,
+			expected: []string{"line 1, column 24: invalid polygon for 'insidePolygon': a polygon needs at least 3 corners, got 2"},
+		},
+		{
+			name:     "Polygon corner that is not a pair",
+			input:    `location insidePolygon [[52.5, 13.3], [52.6], [52.4, 13.5]]`,
+			expected: []string{"line 1, column 24: invalid polygon for 'insidePolygon': corner 2 is not a [latitude, longitude] pair"},
+		},
+		{
+			name:     "Polygon from a field",
+			input:    `location insidePolygon service_area`,
+			expected: []string{"line 1, column 24: operator 'insidePolygon' expects a list of [latitude, longitude] corners, got IDENT 'service_area'"},
+		},
+		{
+			name:     "Nested array for another operator",
+			input:    `pair in [[1, 2]]`,
+			expected: []string{"line 1, column 9: nested arrays are only supported by 'insidePolygon'"},
+		},
+		{
+			name:     "Invalid regex pattern",
+			input:    `sku matches "^SKU-[0-9"`,
+			expected: []string{"line 1, column 13: invalid pattern for 'matches': error parsing regexp: missing closing ]: `[0-9`"},
+		},
+		{
+			name:     "Regex pattern must be a string",
+			input:    `sku matches 10`,
+			expected: []string{"line 1, column 13: operator 'matches' expects a string pattern, got NUMBER '10'"},
+		},
+		{
+			name:     "Regex pattern must not be a list",
+			input:    `sku matches []`,
+			expected: []string{"line 1, column 13: operator 'matches' expects a string pattern, got LSQUARE '['"},
+		},
+		{
+			name:     "Negated ieq",
+			input:    `status not ieq "active"`,
+			expected: []string{"line 1, column 12: negation is not supported for operator 'ieq'"},
+		},
+		{
+			name:     "exists takes no value",
+			input:    `field1 exists 10`,
+			expected: []string{"line 1, column 15: unexpected NUMBER '10' at end of statement"},
+		},
+		{
+			name:     "Quantifier without a field",
+			input:    `all 10 eq 10`,
+			expected: []string{"line 1, column 5: expected IDENT, got NUMBER '10'"},
+		},
+		{
+			name:     "Illegal token",
+			input:    `field1 eq "unclosed`,
+			expected: []string{"line 1, column 11: unclosed string"},
+		},
+		{
+			name: "Every error is reported",
+			input: `
+field1 eq 10
+field2 @ 10
+group and
+    field3 not gt 5
+    field4 eq 10
+    field5 between 1
+eq "stray"
+`,
+			expected: []string{
+				"line 3, column 8: unexpected character '@'",
+				"line 5, column 16: negation is not supported for operator 'gt'",
+				"line 7, column 21: expected AND, got DEDENT",
+				"line 8, column 1: expected one of GROUP, IDENT, NUMBER, DURATION, DATETIME, STRING, LPAREN, MINUS, got EQ 'eq'",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestParser(tt.input).Parse()
+
+			errs, ok := err.(ErrorList)
+			if !ok {
+				t.Fatalf("Expected ErrorList, got %T (%v)", err, err)
+			}
+
+			if len(errs) != len(tt.expected) {
+				t.Fatalf("Expected %d errors, got %d: %v", len(tt.expected), len(errs), errs)
+			}
+
+			for i, expected := range tt.expected {
+				if errs[i].Error() != expected {
+					t.Errorf("Expected error %q, got %q", expected, errs[i].Error())
+				}
+			}
+		})
+	}
+}
+
+func TestParseRecovers(t *testing.T) {
+	input := `
+field1 eq
+field2 eq 10
+group and
+    field3 not eq 5
+    field4 eq 20
+`
+	p := newTestParser(input)
+	nodes, err := p.Parse()
+
+	if err == nil {
+		t.Fatalf("Expected an error but got none")
+	}
+
+	if len(nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(nodes))
+	}
+
+	assertCondition(t, nodes[0], "field2", "eq", Value{10.0}, false)
+	group := assertGroup(t, nodes[1], "and", 1)
+	assertCondition(t, group.Children[0], "field4", "eq", Value{20.0}, false)
+
+	errs := p.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %d", len(errs))
+	}
+
+	if errs[0].Token.Kind != lexer.IDENT || errs[0].Pos.Line != 3 {
+		t.Errorf("Unexpected first error: %v", errs[0])
+	}
+	if len(errs[0].Expected) == 0 {
+		t.Errorf("Expected the first error to list the expected token kinds")
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `
+group and
+    field1 eq 10
+    field2 in [1, 2]
+`
+	p := newTestParser(input)
+	group := assertGroup(t, p.ParseNext(), "and", 2)
+
+	if group.Pos != (lexer.Position{Offset: 1, Line: 2, Column: 1}) {
+		t.Errorf("Unexpected group start: %v", group.Pos)
+	}
+	if group.End != (lexer.Position{Offset: 48, Line: 4, Column: 21}) {
+		t.Errorf("Unexpected group end: %v", group.End)
+	}
+
+	first := assertCondition(t, group.Children[0], "field1", "eq", Value{10.0}, false)
+	if first.Pos != (lexer.Position{Offset: 15, Line: 3, Column: 5}) {
+		t.Errorf("Unexpected condition start: %v", first.Pos)
+	}
+	if first.End != (lexer.Position{Offset: 27, Line: 3, Column: 17}) {
+		t.Errorf("Unexpected condition end: %v", first.End)
+	}
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateTimeLayouts are the forms of date/time literals. Literals without a zone
+// are in UTC.
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseDateTime parses a date/time in one of the forms of Logix literals, like
+// 2024-01-31 or 2024-01-31T09:30:00+02:00. The evaluator uses it for times
+// given as strings in the context.
+func ParseDateTime(literal string) (time.Time, error) {
+	var err error
+	for _, layout := range dateTimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, literal); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+// durationUnits maps the units of duration literals to their length.
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+}
+
+// parseDuration parses a duration literal like 30s, 7d or 1h30m. A sign
+// applies to the whole duration.
+func parseDuration(literal string) (time.Duration, error) {
+	rest := strings.TrimLeft(literal, "+-")
+	sign := 1.0
+	if strings.HasPrefix(literal, "-") {
+		sign = -1
+	}
+
+	var total float64
+	for rest != "" {
+		numberEnd := strings.IndexAny(rest, "smhd")
+		if numberEnd <= 0 {
+			return 0, fmt.Errorf("missing unit in '%s'", literal)
+		}
+
+		unitEnd := numberEnd
+		for unitEnd < len(rest) && strings.IndexByte("smhd", rest[unitEnd]) >= 0 {
+			unitEnd++
+		}
+
+		number, err := strconv.ParseFloat(strings.ReplaceAll(rest[:numberEnd], "_", ""), 64)
+		if err != nil {
+			return 0, err
+		}
+
+		unit, ok := durationUnits[rest[numberEnd:unitEnd]]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit '%s'", rest[numberEnd:unitEnd])
+		}
+
+		total += number * float64(unit)
+		rest = rest[unitEnd:]
+	}
+
+	if total >= math.MaxInt64 {
+		return 0, fmt.Errorf("duration '%s' is out of range", literal)
+	}
+
+	// Rounded, as fractions like 1.000001ms are not exact in binary
+	return time.Duration(math.Round(sign * total)), nil
+}
+
+// formatDuration writes the duration in the largest unit that holds it
+// exactly, like 7d or 90m, so that it reads back as the same literal.
+// Durations that are not whole milliseconds are written as fractions of a
+// millisecond, like 0.1ms, as literals have no smaller unit.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+
+	for _, unit := range []string{"d", "h", "m", "s", "ms"} {
+		if length := durationUnits[unit]; d%length == 0 {
+			return strconv.FormatInt(int64(d/length), 10) + unit
+		}
+	}
+
+	whole, fraction := d/time.Millisecond, d%time.Millisecond
+	sign := ""
+	if d < 0 {
+		sign, whole, fraction = "-", -whole, -fraction
+	}
+
+	return fmt.Sprintf("%s%d.%s", sign, whole, strings.TrimRight(fmt.Sprintf("%06d", fraction), "0")) + "ms"
+}
@@ -0,0 +1,76 @@
+package logix
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alicavdar/logix/evaluator"
+	"github.com/alicavdar/logix/lexer"
+	"github.com/alicavdar/logix/parser"
+)
+
+// Rule is a compiled Logix rule. It holds the parsed syntax tree and never
+// modifies it, so a single Rule can be evaluated from many goroutines at once.
+type Rule struct {
+	program *evaluator.Program
+}
+
+// Compile parses the Logix source once and returns a Rule that can be
+// evaluated any number of times.
+//
+// If the source is malformed, or calls a function that does not exist or with
+// the wrong number of arguments, the error is a parser.ErrorList describing
+// every problem found.
+func Compile(logixContent string) (*Rule, error) {
+	return CompileWithOptions(logixContent, evaluator.Options{})
+}
+
+// CompileWithOptions is like Compile but lets the caller tune how the rule is
+// evaluated, for example the order in which group children are checked, or
+// give it an evaluator.Env with custom operators and functions.
+func CompileWithOptions(logixContent string, options evaluator.Options) (*Rule, error) {
+	lex := lexer.NewLexer(logixContent)
+	pr := parser.NewParser(lex)
+	if options.Env != nil {
+		options.Env.DeclareOperators(pr)
+	}
+
+	nodes, err := pr.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	program, err := evaluator.Compile(nodes, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rule{program: program}, nil
+}
+
+// MustCompile is like Compile but panics if the source cannot be parsed.
+func MustCompile(logixContent string) *Rule {
+	rule, err := Compile(logixContent)
+	if err != nil {
+		panic(fmt.Sprintf("logix: Compile: %v", err))
+	}
+
+	return rule
+}
+
+func (r *Rule) Evaluate(context map[string]interface{}) (bool, error) {
+	return r.program.Evaluate(context)
+}
+
+// EvaluateWithContext is like Evaluate, and passes ctx on to the custom
+// functions the rule calls, so that they can be cancelled.
+func (r *Rule) EvaluateWithContext(ctx context.Context, data map[string]interface{}) (bool, error) {
+	return r.program.EvaluateWithContext(ctx, data)
+}
+
+// Explain evaluates the rule like Evaluate and returns a trace showing the
+// result of every group and condition, the values they were compared with,
+// and which ones were skipped.
+func (r *Rule) Explain(context map[string]interface{}) (*evaluator.Trace, error) {
+	return r.program.Explain(context)
+}